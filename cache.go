@@ -1,58 +1,124 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
-	"net/http"
-	"strings"
 	"sync"
 	"time"
+
+	"immich-ipad/immich"
 )
 
 type PhotoCache struct {
 	mu sync.Mutex
-	// maxPages holds the effective page count per configured device model.
+	// sources are the models and albums (narrowed to PEOPLE, if set) photos
+	// are drawn from, one copy per SELECTION pool, resolved by refreshTotal.
+	// maxPages holds the effective page count per source key.
+	sources  []Source
 	maxPages map[string]int
 	queue    []PhotoInfo
 	shown    map[string]bool
-	client   *http.Client
-	cfg      Config
+	// bags hold each pool's shuffled order for the cycle, which is number
+	// cycle, from seed. deferred holds near-duplicates put off until they no
+	// longer resemble anything recently shown, and the photos sweep found
+	// the cycle had missed; swept is set once it has looked.
+	bags     []shuffleBag
+	cycle    int
+	seed     int64
+	deferred []PhotoInfo
+	swept    bool
+	// index holds, in INDEX_MODE, every photo of each source by key; its
+	// counts stand in for the probed page counts in maxPages.
+	index map[string]indexedSource
+	// checked is when each source was last asked for new uploads, and fresh
+	// holds the photos uploaded within FRESH_DAYS.
+	checked map[string]time.Time
+	fresh   []freshPhoto
+	// cycleStart is when the current no-repeat cycle began.
+	cycleStart time.Time
+	// memories holds the photos taken on memoryDay in previous years.
+	memories  []PhotoInfo
+	memoryDay string
+	// enrich, if set, fills in a photo's details (location, a pre-rendered
+	// image) before it is queued, so /random can answer straight away.
+	enrich func(*PhotoInfo)
+	// history holds the most recently shown photos, oldest first; historyPos
+	// is the one on screen.
+	history    []PhotoInfo
+	historyPos int
+	// recent is the rolling window of shown photos near-duplicates are
+	// checked against.
+	recent []PhotoInfo
+	// hidden, if set, holds the photos never to show; it is shared by all
+	// frames.
+	hidden *hiddenList
+	// exclude holds the junk rules fetchPage applies.
+	exclude exclusionRules
+	// wake nudges the prefetcher; ready is signalled when a photo is queued.
+	wake  chan struct{}
+	ready chan struct{}
+	api   *immich.Client
+	cfg   Config
+}
+
+func newPhotoCache(cfg Config, api *immich.Client) *PhotoCache {
+	return &PhotoCache{
+		shown:      make(map[string]bool),
+		maxPages:   make(map[string]int),
+		index:      make(map[string]indexedSource),
+		checked:    make(map[string]time.Time),
+		cycleStart: time.Now(),
+		seed:       newSeed(cfg),
+		exclude:    newExclusionRules(cfg),
+		api:        api,
+		cfg:        cfg,
+		wake:       make(chan struct{}, 1),
+		ready:      make(chan struct{}, 1),
+	}
 }
 
-// totalPages returns the combined page count across all models. Caller must hold c.mu.
+// totalPages returns the combined page count across all sources. The other
+// pools are subsets of an "all" pool, so when there is one only it counts.
+// Caller must hold c.mu.
 func (c *PhotoCache) totalPages() int {
+	all := -1
+	for i, p := range c.pools() {
+		if !p.Favorites && p.MinRating == 0 {
+			all = i
+		}
+	}
 	total := 0
-	for _, n := range c.maxPages {
-		total += n
+	for _, src := range c.sources {
+		if all < 0 || src.Pool == all {
+			total += c.maxPages[src.key()]
+		}
 	}
 	return total
 }
 
-// probe reports whether a page still returns assets for a model. An API failure
+// probe reports whether a page still returns assets for a source. An API failure
 // is returned as an error rather than "no assets" — treating a failed request as
 // an empty page would make the search below converge on a bogus page count.
-func (c *PhotoCache) probe(model string, page int) (bool, error) {
-	_, raw, err := c.fetchPage(model, page, 1)
+func (c *PhotoCache) probe(src Source, page int) (bool, error) {
+	_, raw, err := c.fetchPage(src, page, 1)
 	if err != nil {
 		return false, err
 	}
 	return raw > 0, nil
 }
 
-// maxPageFor finds the last page that still returns assets for a model. prev is
+// maxPageFor finds the last page that still returns assets for a source. prev is
 // the previously known count (0 if unknown): when set, the search gallops out
 // from there, which costs a handful of requests instead of the ~17 a full binary
 // search over the whole library needs. Returns 0 only if page 1 is genuinely empty.
-func (c *PhotoCache) maxPageFor(model string, prev, upper int) (int, error) {
+func (c *PhotoCache) maxPageFor(src Source, prev, upper int) (int, error) {
 	// Bracket the boundary as (low, high]: low has assets, high does not.
 	low, high := 0, upper+1
 
 	if prev > 0 && prev <= upper {
-		ok, err := c.probe(model, prev)
+		ok, err := c.probe(src, prev)
 		if err != nil {
 			return 0, err
 		}
@@ -63,7 +129,7 @@ func (c *PhotoCache) maxPageFor(model string, prev, upper int) (int, error) {
 				if next > upper {
 					break
 				}
-				ok, err := c.probe(model, next)
+				ok, err := c.probe(src, next)
 				if err != nil {
 					return 0, err
 				}
@@ -80,7 +146,7 @@ func (c *PhotoCache) maxPageFor(model string, prev, upper int) (int, error) {
 				if next < 1 {
 					break
 				}
-				ok, err := c.probe(model, next)
+				ok, err := c.probe(src, next)
 				if err != nil {
 					return 0, err
 				}
@@ -95,7 +161,7 @@ func (c *PhotoCache) maxPageFor(model string, prev, upper int) (int, error) {
 
 	for low+1 < high {
 		mid := low + (high-low)/2
-		ok, err := c.probe(model, mid)
+		ok, err := c.probe(src, mid)
 		if err != nil {
 			return 0, err
 		}
@@ -108,56 +174,71 @@ func (c *PhotoCache) maxPageFor(model string, prev, upper int) (int, error) {
 	return low, nil
 }
 
-// refreshTotal rediscovers the page count per device model. It reports whether
-// any model has a usable count, so the caller knows to keep retrying.
+// refreshTotal rediscovers the page count per source. It reports whether any
+// source has a usable count, so the caller knows to keep retrying.
 func (c *PhotoCache) refreshTotal() bool {
 	// First get upper bound from statistics API
-	req, err := http.NewRequest("GET", c.cfg.ImmichURL+"/api/assets/statistics", nil)
-	if err != nil {
-		log.Printf("Statistics request error: %v", err)
-		return false
-	}
-	req.Header.Set("x-api-key", c.cfg.ImmichAPIKey)
-
-	resp, err := c.client.Do(req)
+	stats, err := c.api.Statistics(context.Background())
 	if err != nil {
 		log.Printf("Statistics API error: %v", err)
 		return false
 	}
-	defer resp.Body.Close()
-
-	var stats struct {
-		Images int `json:"images"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
-		log.Printf("Statistics decode error: %v", err)
+	if stats.Images == 0 {
 		return false
 	}
 
-	if stats.Images == 0 {
-		return false
+	sources, err := c.resolveSources()
+	if err != nil {
+		// Keep the sources resolved last time; album lookups fail the same way
+		// the probes below do while Immich is restarting.
+		log.Printf("Source resolution failed, keeping previous sources: %v", err)
+		c.mu.Lock()
+		sources = c.sources
+		c.mu.Unlock()
+	} else {
+		c.mu.Lock()
+		c.sources = sources
+		c.mu.Unlock()
+	}
+	c.refreshExcludedTags()
+	if c.cfg.IndexMode {
+		c.pruneIndex(sources)
 	}
 
-	for _, model := range c.cfg.DeviceModels {
+	walked := false
+	for _, src := range sources {
+		key := src.key()
 		c.mu.Lock()
-		prev := c.maxPages[model]
+		prev := c.maxPages[key]
 		c.mu.Unlock()
 
-		n, err := c.maxPageFor(model, prev, stats.Images)
+		var n int
+		if c.cfg.IndexMode {
+			var fresh bool
+			n, fresh, err = c.refreshIndex(src)
+			walked = walked || fresh
+		} else {
+			n, err = c.maxPageFor(src, prev, stats.Images)
+		}
 		if err != nil {
 			// Keep whatever we knew before: a transient Immich outage must not
-			// drop a model out of the rotation.
-			log.Printf("Page count probe for %q failed, keeping %d: %v", model, prev, err)
+			// drop a source out of the rotation.
+			log.Printf("Counting %q failed, keeping %d: %v", src.Name, prev, err)
 			continue
 		}
 
 		c.mu.Lock()
 		if n != prev {
-			log.Printf("Updating maxPage for %q: %d -> %d (total images: %d)", model, prev, n, stats.Images)
-			c.maxPages[model] = n
+			log.Printf("Updating maxPage for %q: %d -> %d (total images: %d)", src.Name, prev, n, stats.Images)
+			c.maxPages[key] = n
 		}
 		c.mu.Unlock()
 	}
+	if walked {
+		if err := c.saveIndex(); err != nil {
+			log.Printf("Index save error: %v", err)
+		}
+	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -167,129 +248,260 @@ func (c *PhotoCache) refreshTotal() bool {
 // startRefreshLoop refreshes the page counts every hour, retrying quickly until
 // the first success. Immich is often not reachable yet when this container
 // starts; without the fast retry the frame would stay blank for a full hour.
+// In between it checks for new uploads every few minutes.
 func (c *PhotoCache) startRefreshLoop() {
 	go func() {
 		for !c.refreshTotal() {
 			time.Sleep(1 * time.Minute)
 		}
-		for range time.NewTicker(1 * time.Hour).C {
-			c.refreshTotal()
+		c.refreshMemories(time.Now())
+		c.refreshUploads(time.Now())
+		hourly := time.NewTicker(1 * time.Hour)
+		uploads := time.NewTicker(uploadCheck)
+		for {
+			select {
+			case <-hourly.C:
+				c.refreshTotal()
+				c.refreshMemories(time.Now())
+			case <-uploads.C:
+			}
+			c.refreshUploads(time.Now())
 		}
 	}()
 }
 
-// pickModel chooses a device model at random, weighted by how many photos each
-// one has, so every photo across all models is equally likely to be picked.
-// Returns the model and its page count, or ("", 0) if nothing is available yet.
-// Caller must hold c.mu.
-func (c *PhotoCache) pickModel() (string, int) {
-	total := c.totalPages()
-	if total == 0 {
-		return "", 0
+// fillQueue picks one photo from a random page of a random source, or for
+// MEMORIES_PERCENT of the picks, one taken on this day in a previous year, and
+// queues it once enrich has filled in its details. It reports whether a photo
+// was queued. c.mu is only held while choosing and queueing, never across a
+// request to Immich.
+func (c *PhotoCache) fillQueue() bool {
+	p, ok := c.pick()
+	if !ok {
+		return false
 	}
-	r := rand.Intn(total)
-	for _, model := range c.cfg.DeviceModels {
-		n := c.maxPages[model]
-		if r < n {
-			return model, n
-		}
-		r -= n
+	if c.enrich != nil {
+		c.enrich(&p)
 	}
-	return "", 0
+	return c.enqueue(p)
 }
 
-// fillQueue fetches 1 photo from a random page of a random device model
-func (c *PhotoCache) fillQueue() {
+// pick chooses the next photo to queue without queueing it.
+func (c *PhotoCache) pick() (PhotoInfo, bool) {
+	c.mu.Lock()
 	if c.totalPages() == 0 {
+		c.mu.Unlock()
 		log.Printf("Page counts not yet initialized, waiting for statistics refresh")
-		return
+		return PhotoInfo{}, false
+	}
+	if c.cfg.MemoriesPercent > 0 && rand.Intn(100) < c.cfg.MemoriesPercent {
+		if p, ok := c.pickMemory(); ok {
+			c.mu.Unlock()
+			return p, true
+		}
 	}
+	if c.cfg.FreshDays > 0 && rand.Intn(100) < c.cfg.FreshPercent {
+		if p, ok := c.pickFresh(); ok {
+			c.mu.Unlock()
+			return p, true
+		}
+	}
+	c.mu.Unlock()
+
+	// Slots whose photo was shown out of turn, hidden or excluded are passed
+	// over; a few in a row are fine, the next pick carries on from there.
 	for retries := 0; retries < 10; retries++ {
-		model, maxPage := c.pickModel()
-		if maxPage == 0 {
-			return
+		c.mu.Lock()
+		if c.sweepDue() {
+			c.mu.Unlock()
+			if !c.sweep() {
+				return PhotoInfo{}, false
+			}
+			c.mu.Lock()
+		}
+		if p, ok := c.takeDeferred(); ok {
+			c.mu.Unlock()
+			log.Printf("Picked %s, put off earlier or missed by the cycle", p.ID)
+			return p, true
+		}
+		src, offset, ok := c.pickSource()
+		due := c.sweepDue()
+		c.mu.Unlock()
+		if !ok && due {
+			continue
+		}
+		if !ok {
+			return PhotoInfo{}, false
 		}
-		page := rand.Intn(maxPage) + 1
-		photos, _, err := c.fetchPage(model, page, 1)
+		p, from, ok, err := c.draw(src, offset)
 		if err != nil {
-			log.Printf("Fetch page %d of %q failed: %v", page, model, err)
+			// Immich is unreachable: keep the slot for the next attempt
+			// rather than lose the photo for the rest of the cycle.
+			log.Printf("Fetching %s failed: %v", from, err)
+			c.mu.Lock()
+			c.putBack(src.Pool)
+			c.mu.Unlock()
+			return PhotoInfo{}, false
+		}
+		if !ok {
 			continue
 		}
-		if len(photos) == 0 {
+
+		c.mu.Lock()
+		// The photo on screen is not in a new cycle's shown set yet; it must
+		// not come straight back.
+		fresh := !c.shown[p.ID] && !c.queued(p.ID) && !c.onScreen(p.ID)
+		similar := fresh && c.nearDuplicate(p)
+		if similar {
+			c.deferred = append(c.deferred, p)
+		}
+		shown := len(c.shown)
+		c.mu.Unlock()
+		if similar {
+			log.Printf("Putting off %s: too like a recent photo", p.ID)
 			continue
 		}
-		p := photos[0]
-		if !c.shown[p.ID] {
-			c.queue = append(c.queue, p)
-			log.Printf("Fetched page %d of %q (shown: %d, maxPage: %d)", page, model, len(c.shown), maxPage)
-			return
+		if fresh {
+			log.Printf("Picked %s from %s (shown: %d)", p.ID, from, shown)
+			return p, true
 		}
 	}
+	return PhotoInfo{}, false
 }
 
-// fetchPage returns the photos on a page for one device model, along with the
-// number of assets the API returned before screenshots were filtered out. That
-// raw count is what tells a page past the end of the results (0 assets) apart
-// from a page that only held screenshots. A non-nil error means the count is
-// unknown, which callers must not confuse with a count of zero.
-func (c *PhotoCache) fetchPage(model string, page, pageSize int) ([]PhotoInfo, int, error) {
-	searchBody := map[string]interface{}{
-		"type":       "IMAGE",
-		"page":       page,
-		"size":       pageSize,
-		"model":      model,
-		"visibility": "timeline",
-	}
-
-	bodyBytes, err := json.Marshal(searchBody)
-	if err != nil {
-		return nil, 0, err
+// draw fetches the photo at an offset within a source: from its index in
+// INDEX_MODE, otherwise as the page of that number with one photo per page.
+// It also says where the photo came from, for the log. It reports false if
+// the photo is hidden or excluded, and an error if Immich could not be asked.
+func (c *PhotoCache) draw(src Source, offset int) (PhotoInfo, string, bool, error) {
+	if c.cfg.IndexMode {
+		from := fmt.Sprintf("the index of %q", src.Name)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		entries := c.index[src.key()].Entries
+		if offset >= len(entries) {
+			return PhotoInfo{}, from, false, nil
+		}
+		e := entries[offset]
+		if c.hidden.has(e.ID) || c.exclude.tagged[e.ID] {
+			return PhotoInfo{}, from, false, nil
+		}
+		return e.photo(localeFor(c.cfg.Locale)), from, true, nil
 	}
-
-	req, err := http.NewRequest("POST", c.cfg.ImmichURL+"/api/search/metadata", bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, 0, err
+	page := offset + 1
+	from := fmt.Sprintf("page %d of %q", page, src.Name)
+	photos, _, err := c.fetchPage(src, page, 1)
+	if err != nil || len(photos) == 0 {
+		return PhotoInfo{}, from, false, err
 	}
-	req.Header.Set("x-api-key", c.cfg.ImmichAPIKey)
-	req.Header.Set("Content-Type", "application/json")
+	return photos[0], from, true, nil
+}
 
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, 0, err
+// queued reports whether a photo is already waiting in the queue. Caller must
+// hold c.mu.
+func (c *PhotoCache) queued(id string) bool {
+	for _, q := range c.queue {
+		if q.ID == id {
+			return true
+		}
 	}
-	defer resp.Body.Close()
+	return false
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, 0, fmt.Errorf("search API status %d: %s", resp.StatusCode, string(body))
+// enqueue appends a picked photo to the queue, unless it was shown or queued by
+// someone else while it was being enriched, or its perceptual hash, known only
+// now, gives it away as a near-duplicate.
+func (c *PhotoCache) enqueue(p PhotoInfo) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shown[p.ID] || c.queued(p.ID) {
+		return false
 	}
+	if c.nearDuplicate(p) {
+		c.deferred = append(c.deferred, p)
+		return false
+	}
+	c.queue = append(c.queue, p)
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
 
-	var result searchResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+// fetchPage returns the photos on a page for one source, along with the
+// number of assets the API returned before excluded ones were filtered out.
+// That raw count is what tells a page past the end of the results (0 assets)
+// apart from a page that only held excluded assets. A non-nil error means the
+// count is unknown, which callers must not confuse with a count of zero.
+func (c *PhotoCache) fetchPage(src Source, page, pageSize int) ([]PhotoInfo, int, error) {
+	q, rules := c.search(src, page, pageSize)
+	result, err := c.api.SearchMetadata(context.Background(), q)
+	if err != nil {
 		return nil, 0, err
 	}
 
 	var photos []PhotoInfo
-	for _, a := range result.Assets.Items {
-		if strings.Contains(strings.ToLower(a.OriginalFileName), "screenshot") {
+	for _, a := range result.Items {
+		if reason, ok := rules.excludes(a); ok {
+			if pageSize == 1 {
+				log.Printf("Skipping %s: excluded by %s", a.OriginalFileName, reason)
+			}
+			continue
+		}
+		if c.hidden.has(a.ID) {
 			continue
 		}
-		photos = append(photos, PhotoInfo{
-			ID:   a.ID,
-			Date: formatDate(a.FileCreatedAt),
-		})
+		photos = append(photos, entryFor(a).photo(localeFor(c.cfg.Locale)))
 	}
 
-	return photos, len(result.Assets.Items), nil
+	return photos, len(result.Items), nil
 }
 
-func (c *PhotoCache) next() *PhotoInfo {
+// search returns the query for a page of a source's photos, and the exclusion
+// rules to apply to what it finds.
+func (c *PhotoCache) search(src Source, page, pageSize int) (immich.MetadataQuery, exclusionRules) {
+	q := immich.MetadataQuery{
+		Type:       "IMAGE",
+		Page:       page,
+		Size:       pageSize,
+		Visibility: "timeline",
+	}
+	src.filters(&q)
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	rules := c.exclude
+	c.mu.Unlock()
+	q.WithExif = rules.needsExif() || c.cfg.BurstSeconds > 0
+	return q, rules
+}
 
+// next pops the next photo off the queue. The prefetcher normally has one
+// ready; if not, next waits a little for it rather than fetch on its own.
+// Returns nil if nothing turned up in time. After previous has stepped back,
+// next first walks forward through the history again.
+func (c *PhotoCache) next() *PhotoInfo {
+	c.mu.Lock()
+	if c.historyPos < len(c.history)-1 {
+		c.historyPos++
+		p := c.history[c.historyPos]
+		c.mu.Unlock()
+		return &p
+	}
 	if len(c.queue) == 0 {
-		c.fillQueue()
+		// Drop a stale signal so the wait below is for a new photo.
+		select {
+		case <-c.ready:
+		default:
+		}
+		c.mu.Unlock()
+		c.wakePrefetcher()
+		select {
+		case <-c.ready:
+		case <-time.After(nextWait):
+		}
+		c.mu.Lock()
 	}
+	defer c.mu.Unlock()
 	if len(c.queue) == 0 {
 		return nil
 	}
@@ -297,12 +509,98 @@ func (c *PhotoCache) next() *PhotoInfo {
 	p := c.queue[0]
 	c.queue = c.queue[1:]
 	c.shown[p.ID] = true
+	c.remember(p)
+	c.addRecent(p)
+	c.wakePrefetcher()
+	return &p
+}
+
+// historySize is how many photos previous can step back through.
+const historySize = 50
+
+// remember records a photo as the one now on screen. Caller must hold c.mu.
+func (c *PhotoCache) remember(p PhotoInfo) {
+	c.history = append(c.history, p)
+	if len(c.history) > historySize {
+		c.history = c.history[len(c.history)-historySize:]
+	}
+	c.historyPos = len(c.history) - 1
+}
 
-	// Reset shown set when all photos have been shown
-	if len(c.shown) >= c.totalPages() {
-		log.Printf("All %d photos shown, resetting cycle", len(c.shown))
-		c.shown = make(map[string]bool)
+// current returns the photo on screen, or nil before the first one.
+func (c *PhotoCache) current() *PhotoInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.history) == 0 {
+		return nil
 	}
+	p := c.history[c.historyPos]
+	return &p
+}
+
+// onScreen reports whether a photo is the one on screen. Caller must hold c.mu.
+func (c *PhotoCache) onScreen(id string) bool {
+	return len(c.history) > 0 && c.history[c.historyPos].ID == id
+}
 
+// previous steps back to the photo shown before the current one. Returns nil
+// at the start of the history.
+func (c *PhotoCache) previous() *PhotoInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.historyPos <= 0 {
+		return nil
+	}
+	c.historyPos--
+	p := c.history[c.historyPos]
 	return &p
 }
+
+// drop removes a hidden photo from the queue, today's memories and the history.
+// It reports whether the photo was the one on screen.
+func (c *PhotoCache) drop(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shown[id] = true
+	onScreen := c.onScreen(id)
+
+	queue := c.queue[:0]
+	for _, p := range c.queue {
+		if p.ID != id {
+			queue = append(queue, p)
+		}
+	}
+	c.queue = queue
+
+	var memories []PhotoInfo
+	for _, p := range c.memories {
+		if p.ID != id {
+			memories = append(memories, p)
+		}
+	}
+	c.memories = memories
+
+	var history []PhotoInfo
+	pos := c.historyPos
+	for i, p := range c.history {
+		if p.ID == id {
+			if i <= c.historyPos && pos > 0 {
+				pos--
+			}
+			continue
+		}
+		history = append(history, p)
+	}
+	c.history = history
+	c.historyPos = min(pos, len(history)-1)
+	return onScreen
+}
+
+// showing records a photo put on screen out of turn, by asset ID. It becomes
+// the newest history entry, so previous leads back to what was on before.
+func (c *PhotoCache) showing(p PhotoInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shown[p.ID] = true
+	c.remember(p)
+}
@@ -1,68 +1,20 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
-	"net/http/httptest"
-	"sync"
 	"testing"
-)
-
-// fakeImmich serves the two endpoints the cache uses. Pages 1..max return one
-// asset for the model; beyond that they come back empty. failNext makes the
-// next n search calls fail, to simulate Immich restarting.
-type fakeImmich struct {
-	mu       sync.Mutex
-	max      map[string]int
-	calls    int
-	failNext int
-}
-
-func (f *fakeImmich) handler() http.Handler {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/assets/statistics", func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(map[string]int{"images": 100000})
-	})
-	mux.HandleFunc("/api/search/metadata", func(w http.ResponseWriter, r *http.Request) {
-		var body struct {
-			Page  int    `json:"page"`
-			Model string `json:"model"`
-		}
-		json.NewDecoder(r.Body).Decode(&body)
-
-		f.mu.Lock()
-		f.calls++
-		if f.failNext > 0 {
-			f.failNext--
-			f.mu.Unlock()
-			http.Error(w, "immich is restarting", http.StatusBadGateway)
-			return
-		}
-		max := f.max[body.Model]
-		f.mu.Unlock()
 
-		items := "[]"
-		if body.Page >= 1 && body.Page <= max {
-			items = fmt.Sprintf(`[{"id":"p%d","fileCreatedAt":"2024-01-01T00:00:00.000Z","originalFileName":"IMG_%d.HEIC"}]`, body.Page, body.Page)
-		}
-		fmt.Fprintf(w, `{"assets":{"items":%s,"nextPage":""}}`, items)
-	})
-	return mux
-}
+	"immich-ipad/immich/immichtest"
+)
 
-func newTestCache(t *testing.T, models []string, max map[string]int) (*PhotoCache, *fakeImmich) {
+// newTestCache returns a cache backed by a fake Immich with the given page
+// counts; see immichtest.Server for how searches are keyed.
+func newTestCache(t *testing.T, models []string, pages map[string]int) (*PhotoCache, *immichtest.Server) {
 	t.Helper()
-	fake := &fakeImmich{max: max}
-	srv := httptest.NewServer(fake.handler())
-	t.Cleanup(srv.Close)
-
-	return &PhotoCache{
-		maxPages: make(map[string]int),
-		shown:    make(map[string]bool),
-		client:   srv.Client(),
-		cfg:      Config{ImmichURL: srv.URL, DeviceModels: models},
-	}, fake
+	fake := immichtest.NewServer(pages)
+	t.Cleanup(fake.Close)
+
+	return newPhotoCache(Config{ImmichURL: fake.URL, DeviceModels: models}, fake.Client()), fake
 }
 
 func TestRefreshTotalFindsEachModel(t *testing.T) {
@@ -88,9 +40,7 @@ func TestTransientFailureKeepsPreviousCount(t *testing.T) {
 		t.Fatal("initial refresh failed")
 	}
 
-	fake.mu.Lock()
-	fake.failNext = 1000 // every probe fails from here on
-	fake.mu.Unlock()
+	fake.FailNext(1000) // every probe fails from here on
 
 	c.refreshTotal()
 
@@ -107,16 +57,12 @@ func TestRefreshFromKnownCountIsCheap(t *testing.T) {
 	c, fake := newTestCache(t, []string{"iPhone 14 Pro"}, map[string]int{"iPhone 14 Pro": 91117})
 	c.refreshTotal()
 
-	fake.mu.Lock()
-	fake.max["iPhone 14 Pro"] = 91120 // three new photos arrived
-	cold := fake.calls
-	fake.mu.Unlock()
+	fake.SetPages("iPhone 14 Pro", 91120) // three new photos arrived
+	cold := fake.Calls()
 
 	c.refreshTotal()
 
-	fake.mu.Lock()
-	warm := fake.calls - cold
-	fake.mu.Unlock()
+	warm := fake.Calls() - cold
 
 	if c.maxPages["iPhone 14 Pro"] != 91120 {
 		t.Errorf("maxPages = %d, want 91120", c.maxPages["iPhone 14 Pro"])
@@ -137,8 +83,221 @@ func TestModelWithNoPhotosIsExcluded(t *testing.T) {
 		t.Errorf("Pixel 9 = %d, want 0", got)
 	}
 	for i := 0; i < 50; i++ {
-		if model, _ := c.pickModel(); model != "iPhone 14 Pro" {
-			t.Fatalf("pickModel returned %q, want only iPhone 14 Pro", model)
+		if src, _, _ := c.pickSource(); src.Model != "iPhone 14 Pro" {
+			t.Fatalf("pickSource returned %q, want only iPhone 14 Pro", src.Name)
+		}
+	}
+}
+
+const frameAlbumID = "0b7c8e0e-6a43-4a8e-9d54-3f1f6f0c2a11"
+
+func TestAlbumSourcesResolveByName(t *testing.T) {
+	c, fake := newTestCache(t, []string{"iPhone XS"}, map[string]int{
+		"iPhone XS": 300, frameAlbumID: 100,
+	})
+	fake.AddAlbum(frameAlbumID, "Frame")
+	c.cfg.Albums = []string{"Frame", "Missing"}
+
+	if ok := c.refreshTotal(); !ok {
+		t.Fatal("refreshTotal reported no usable counts")
+	}
+	if len(c.sources) != 2 {
+		t.Fatalf("got %d sources, want the model and the Frame album", len(c.sources))
+	}
+	if got := c.maxPages["album:"+frameAlbumID]; got != 100 {
+		t.Errorf("album pages = %d, want 100", got)
+	}
+
+	// A cycle draws every photo once, so the album, a quarter of the photos,
+	// gets a quarter of the picks.
+	albumPicks := 0
+	for i := 0; i < 400; i++ {
+		src, _, ok := c.pickSource()
+		if !ok {
+			t.Fatalf("pickSource ran out after %d of 400 picks", i)
 		}
+		if src.AlbumID == frameAlbumID {
+			albumPicks++
+		}
+	}
+	if albumPicks != 100 {
+		t.Errorf("album picked %d of 400 times, want 100", albumPicks)
+	}
+}
+
+func TestPeopleModes(t *testing.T) {
+	counts := map[string]int{
+		"iPhone XS|kid1": 40, "iPhone XS|kid2": 25, "iPhone XS|kid3": 7, "iPhone XS|kid1+kid2": 10,
+	}
+	addPeople := func(fake *immichtest.Server) {
+		fake.AddPerson("kid1", "Deniz")
+		fake.AddPerson("kid2", "Ada")
+		fake.AddPerson("kid3", "Adam")
+	}
+
+	c, fake := newTestCache(t, []string{"iPhone XS"}, counts)
+	addPeople(fake)
+	// "Ad" is only a prefix of Ada and Adam, so it matches nobody.
+	c.cfg.People = []string{"Deniz", "Ada", "Ad", "Nobody"}
+	c.refreshTotal()
+	if got := c.totalPages(); got != 65 {
+		t.Errorf("any-of mode: %d pages, want 65 (one source per person)", got)
+	}
+
+	c, fake = newTestCache(t, []string{"iPhone XS"}, counts)
+	addPeople(fake)
+	c.cfg.People = []string{"Deniz", "Ada"}
+	c.cfg.PeopleMode = "all"
+	c.refreshTotal()
+	if got := c.totalPages(); got != 10 {
+		t.Errorf("all-of mode: %d pages, want 10", got)
+	}
+}
+
+func TestNoPeopleFoundKeepsFrameEmpty(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 500})
+	c.cfg.People = []string{"Nobody"}
+
+	if ok := c.refreshTotal(); ok {
+		t.Fatal("refreshTotal fell back to the unfiltered library")
+	}
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	c, fake := newTestCache(t, []string{"iPhone 14 Pro"}, map[string]int{"iPhone 14 Pro": 91117})
+	c.cfg.StateDir = dir
+	c.refreshTotal()
+	c.shown["p42"] = true
+	if err := c.saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	restarted := newPhotoCache(c.cfg, c.api)
+	if err := restarted.loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if !restarted.shown["p42"] {
+		t.Error("shown set was not restored")
+	}
+
+	before := fake.Calls()
+	restarted.refreshTotal()
+	after := fake.Calls()
+
+	if restarted.maxPages["iPhone 14 Pro"] != 91117 {
+		t.Errorf("maxPages = %d, want 91117", restarted.maxPages["iPhone 14 Pro"])
+	}
+	if after-before > 8 {
+		t.Errorf("refresh after restart took %d requests, want a warm refresh", after-before)
+	}
+}
+
+func TestQueuedPhotosAreEnrichedAndNotRepeated(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 2})
+	c.refreshTotal()
+	c.enrich = func(p *PhotoInfo) { p.City = "Istanbul" }
+
+	for c.fillQueue() {
+	}
+	if len(c.queue) != 2 {
+		t.Fatalf("queued %d photos, want both", len(c.queue))
+	}
+	first := c.next()
+	if first == nil || first.City != "Istanbul" {
+		t.Fatalf("next() = %+v, want an enriched photo", first)
+	}
+	if second := c.next(); second == nil || second.ID == first.ID {
+		t.Errorf("second photo %+v repeats the first", second)
+	}
+}
+
+func TestPreviousStepsBackThroughHistory(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 3})
+	c.refreshTotal()
+	for c.fillQueue() {
+	}
+	first, second := c.next(), c.next()
+
+	if p := c.previous(); p == nil || p.ID != first.ID {
+		t.Fatalf("previous() = %+v, want %s", p, first.ID)
+	}
+	if c.previous() != nil {
+		t.Errorf("previous() stepped back past the first photo")
+	}
+	if p := c.next(); p == nil || p.ID != second.ID {
+		t.Errorf("next() after previous = %+v, want %s again", p, second.ID)
+	}
+}
+
+func TestHiddenPhotosAreSkippedAndDropped(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 3})
+	c.hidden = newHiddenList(Config{}, nil)
+	c.hidden.hide("p2")
+	c.refreshTotal()
+
+	if photos, raw, _ := c.fetchPage(c.sources[0], 2, 1); len(photos) != 0 || raw != 1 {
+		t.Errorf("fetchPage(2) = %d photos (%d raw), want the hidden photo skipped but counted", len(photos), raw)
+	}
+
+	for c.fillQueue() {
+	}
+	shown := c.next()
+	if shown == nil {
+		t.Fatal("next() = nil")
+	}
+	if !c.drop(shown.ID) {
+		t.Errorf("drop(%s) did not report the photo on screen", shown.ID)
+	}
+	if c.previous() != nil {
+		t.Errorf("previous() returned a dropped photo")
+	}
+	for _, p := range c.queue {
+		if p.ID == "p2" || p.ID == shown.ID {
+			t.Errorf("queue still holds %s", p.ID)
+		}
+	}
+}
+
+func TestDateRangesSplitSourcesAroundExcludedYears(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{
+		"iPhone XS|taken:2015-01-01..2020-01-01": 40,
+		"iPhone XS|taken:2021-01-01..":           25,
+		"iPhone XS":                              500,
+	})
+	c.cfg.DateRange, _ = parseDateRange("2015..")
+	c.cfg.ExcludeYears = []int{2020}
+
+	if !c.refreshTotal() {
+		t.Fatal("refreshTotal reported no usable counts")
+	}
+	if len(c.sources) != 2 {
+		t.Fatalf("got %d sources, want the years either side of 2020", len(c.sources))
+	}
+	if got := c.totalPages(); got != 65 {
+		t.Errorf("totalPages() = %d, want 65 from the two windows", got)
+	}
+}
+
+func TestSourceRangeNarrowsGlobalRange(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS@..2019", "iPhone 14 Pro"}, map[string]int{
+		"iPhone XS|taken:2015-01-01..2020-01-01":     30,
+		"iPhone 14 Pro|taken:2015-01-01..":           70,
+		"iPhone XS|taken:..2020-01-01":               99,
+		"iPhone 14 Pro|taken:2015-01-01..2020-01-01": 99,
+	})
+	c.cfg.DateRange, _ = parseDateRange("2015..")
+
+	c.refreshTotal()
+	got := map[string]int{}
+	for _, src := range c.sources {
+		got[src.key()] = c.maxPages[src.key()]
+	}
+	want := map[string]int{
+		"iPhone XS|taken:2015-01-01..2020-01-01": 30,
+		"iPhone 14 Pro|taken:2015-01-01..":       70,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("page counts = %v, want %v", got, want)
 	}
 }
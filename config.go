@@ -1,7 +1,9 @@
 package main
 
 import (
+	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -10,17 +12,58 @@ type Config struct {
 	ImmichURL         string
 	ImmichAPIKey      string
 	DeviceModels      []string
+	Albums            []string
+	People            []string
+	PeopleMode        string
 	SlideshowInterval int
 	Port              string
 	ShowMap           bool
 	ShowWeather       bool
+	ShowForecast      bool
 	WeatherLat        string
 	WeatherLon        string
+	WeatherProvider   string
+	Locale            string
+	Units             string
+	HAURL             string
+	HAToken           string
+	HAWeatherEntity   string
+	StateDir          string
+	FramesFile        string
+	ScreenWidth       int
+	ScreenHeight      int
+	JPEGQuality       int
+	PhotoCacheMB      int
+	PrefetchSize      int
+	MapTileURL        string
+	MapUserAgent      string
+	TileCacheDir      string
+	TileCacheMB       int
+	TileCacheDays     int
+	MemoriesPercent   int
+	MemoriesYears     int
+	HideTag           string
+	ReadOnly          bool
+	Selection         selectionList
+	DateRange         dateRange
+	ExcludeYears      []int
+	ExcludeNames      []string
+	ExcludeMIMETypes  []string
+	ExcludeSizes      []string
+	ExcludeNoCamera   bool
+	ExcludeTags       []string
+	BurstSeconds      int
+	SkipDuplicates    bool
+	PerceptualHash    bool
+	IndexMode         bool
+	ShuffleSeed       int64
+	FreshDays         int
+	FreshPercent      int
 }
 
-// parseDeviceModels splits a comma-separated DEVICE_MODEL value into individual
-// camera models, dropping empty entries and surrounding whitespace/quotes.
-func parseDeviceModels(v string) []string {
+// parseList splits a comma-separated value such as DEVICE_MODELS or ALBUMS into
+// individual entries, dropping empty entries and surrounding whitespace/quotes.
+func parseList(v string) []string {
 	var models []string
 	for _, m := range strings.Split(v, ",") {
 		m = strings.TrimSpace(m)
@@ -47,16 +90,178 @@ func loadConfig() Config {
 	}
 
 	// DEVICE_MODELS is the current name; DEVICE_MODEL is kept as a fallback.
-	deviceModels := parseDeviceModels(os.Getenv("DEVICE_MODELS"))
+	deviceModels := parseList(os.Getenv("DEVICE_MODELS"))
 	if len(deviceModels) == 0 {
-		deviceModels = parseDeviceModels(os.Getenv("DEVICE_MODEL"))
+		deviceModels = parseList(os.Getenv("DEVICE_MODEL"))
 	}
-	if len(deviceModels) == 0 {
+	// ALBUMS holds album IDs or names. The default models only apply when no
+	// source at all is configured, so an album-only frame stays album-only.
+	albums := parseList(os.Getenv("ALBUMS"))
+	if len(deviceModels) == 0 && len(albums) == 0 {
 		deviceModels = []string{"iPhone 14 Pro", "iPhone XS"}
 	}
 
+	// PEOPLE_MODE is "any" (a photo with at least one of PEOPLE) or "all".
+	peopleMode := "any"
+	if os.Getenv("PEOPLE_MODE") == "all" {
+		peopleMode = "all"
+	}
+
+	// MEMORIES_PERCENT is the share of the rotation given to photos taken on
+	// this day in previous years.
+	memoriesPercent := 0
+	if v := os.Getenv("MEMORIES_PERCENT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 100 {
+			memoriesPercent = n
+		}
+	}
+	memoriesYears := 20
+	if v := os.Getenv("MEMORIES_YEARS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			memoriesYears = n
+		}
+	}
+
+	// FRESH_DAYS shows photos uploaded in that many days sooner, taking
+	// FRESH_PERCENT of the picks while any are left to show.
+	freshDays := 0
+	if v := os.Getenv("FRESH_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			freshDays = n
+		}
+	}
+	freshPercent := 50
+	if v := os.Getenv("FRESH_PERCENT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			freshPercent = n
+		}
+	}
+
+	// SCREEN_WIDTH and SCREEN_HEIGHT fix the size photos are resized to; left
+	// unset, the page asks for its own window size.
+	screenWidth, _ := strconv.Atoi(os.Getenv("SCREEN_WIDTH"))
+	screenHeight, _ := strconv.Atoi(os.Getenv("SCREEN_HEIGHT"))
+	jpegQuality := 85
+	if v := os.Getenv("JPEG_QUALITY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 100 {
+			jpegQuality = n
+		}
+	}
+	photoCacheMB := 64
+	if v := os.Getenv("PHOTO_CACHE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			photoCacheMB = n
+		}
+	}
+
+	prefetchSize := 3
+	if v := os.Getenv("PREFETCH_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			prefetchSize = n
+		}
+	}
+
+	// MAP_TILE_URL is a {z}/{x}/{y} template; file:// points at a directory of
+	// pre-rendered tiles. The OSM tile policy wants a contact in the User-Agent.
+	mapTileURL := os.Getenv("MAP_TILE_URL")
+	if mapTileURL == "" {
+		mapTileURL = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+	}
+	mapUserAgent := os.Getenv("MAP_USER_AGENT")
+	if mapUserAgent == "" {
+		mapUserAgent = "immich-ipad/1.0"
+	}
+	if v := os.Getenv("MAP_CONTACT"); v != "" {
+		mapUserAgent += " (" + v + ")"
+	}
+	tileCacheDir := os.Getenv("TILE_CACHE_DIR")
+	if tileCacheDir == "" && os.Getenv("STATE_DIR") != "" {
+		tileCacheDir = filepath.Join(os.Getenv("STATE_DIR"), "tiles")
+	}
+	tileCacheMB := 200
+	if v := os.Getenv("TILE_CACHE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			tileCacheMB = n
+		}
+	}
+	tileCacheDays := 30
+	if v := os.Getenv("TILE_CACHE_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			tileCacheDays = n
+		}
+	}
+
+	// UNITS is "metric" (Celsius) or "imperial" (Fahrenheit).
+	units := "metric"
+	if os.Getenv("UNITS") == "imperial" {
+		units = "imperial"
+	}
+
+	localeName := os.Getenv("LOCALE")
+	checkLocale("Environment", localeName)
+
+	haEntity := os.Getenv("HA_WEATHER_ENTITY")
+	if haEntity == "" {
+		haEntity = "weather.home"
+	}
+
+	// SELECTION picks which pools photos come from and in what share, e.g.
+	// "favorites:50,all:50". Left unset, every photo is equally likely. A
+	// mistake is fatal, as it is in FRAMES_FILE: ignoring it would quietly
+	// draw from the whole library instead.
+	selection, err := parseSelection(os.Getenv("SELECTION"))
+	if err != nil {
+		log.Fatalf("Invalid SELECTION: %v", err)
+	}
+
+	// DATE_RANGE limits every source to photos taken in a window such as
+	// "2015.."; EXCLUDE_YEARS leaves whole years out. A bad range is fatal
+	// like SELECTION, for the same reason.
+	var takenRange dateRange
+	if v := os.Getenv("DATE_RANGE"); v != "" {
+		if takenRange, err = parseDateRange(v); err != nil {
+			log.Fatalf("Invalid DATE_RANGE: %v", err)
+		}
+	}
+	var excludeYears []int
+	for _, v := range parseList(os.Getenv("EXCLUDE_YEARS")) {
+		if n, err := strconv.Atoi(v); err == nil {
+			excludeYears = append(excludeYears, n)
+		} else {
+			log.Printf("Ignoring EXCLUDE_YEARS entry %q", v)
+		}
+	}
+
+	// EXCLUDE_NAMES are file name globs of junk to skip. Screenshots are
+	// skipped unless it is set to something else.
+	excludeNames := []string{"*screenshot*"}
+	if v, ok := os.LookupEnv("EXCLUDE_NAMES"); ok {
+		excludeNames = parseList(v)
+	}
+
+	// BURST_SECONDS keeps photos taken this close to a recently shown one, on
+	// the same device, off the screen for a while.
+	burstSeconds := 0
+	if v := os.Getenv("BURST_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			burstSeconds = n
+		}
+	}
+
+	// SHUFFLE_SEED fixes the playing order, which is otherwise random and
+	// kept in the state across restarts.
+	var shuffleSeed int64
+	if v := os.Getenv("SHUFFLE_SEED"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			shuffleSeed = n
+		} else {
+			log.Printf("Ignoring SHUFFLE_SEED=%q: use a positive number", v)
+		}
+	}
+
 	showMap := os.Getenv("SHOW_MAP") == "true"
 	showWeather := os.Getenv("SHOW_WEATHER") != "false"
+	showForecast := os.Getenv("SHOW_FORECAST") == "true"
 
 	weatherLat := os.Getenv("WEATHER_LAT")
 	if weatherLat == "" {
@@ -71,11 +276,52 @@ func loadConfig() Config {
 		ImmichURL:         os.Getenv("IMMICH_URL"),
 		ImmichAPIKey:      os.Getenv("IMMICH_API_KEY"),
 		DeviceModels:      deviceModels,
+		Albums:            albums,
+		People:            parseList(os.Getenv("PEOPLE")),
+		PeopleMode:        peopleMode,
 		SlideshowInterval: interval,
 		Port:              port,
 		ShowMap:           showMap,
 		ShowWeather:       showWeather,
+		ShowForecast:      showForecast,
 		WeatherLat:        weatherLat,
 		WeatherLon:        weatherLon,
+		WeatherProvider:   os.Getenv("WEATHER_PROVIDER"),
+		Locale:            localeName,
+		Units:             units,
+		HAURL:             os.Getenv("HA_URL"),
+		HAToken:           os.Getenv("HA_TOKEN"),
+		HAWeatherEntity:   haEntity,
+		StateDir:          os.Getenv("STATE_DIR"),
+		FramesFile:        os.Getenv("FRAMES_FILE"),
+		ScreenWidth:       screenWidth,
+		ScreenHeight:      screenHeight,
+		JPEGQuality:       jpegQuality,
+		PhotoCacheMB:      photoCacheMB,
+		PrefetchSize:      prefetchSize,
+		MapTileURL:        mapTileURL,
+		MapUserAgent:      mapUserAgent,
+		TileCacheDir:      tileCacheDir,
+		TileCacheMB:       tileCacheMB,
+		TileCacheDays:     tileCacheDays,
+		MemoriesPercent:   memoriesPercent,
+		MemoriesYears:     memoriesYears,
+		HideTag:           os.Getenv("HIDE_TAG"),
+		ReadOnly:          os.Getenv("READ_ONLY") == "true",
+		Selection:         selection,
+		DateRange:         takenRange,
+		ExcludeYears:      excludeYears,
+		ExcludeNames:      excludeNames,
+		ExcludeMIMETypes:  parseList(os.Getenv("EXCLUDE_MIME_TYPES")),
+		ExcludeSizes:      parseList(os.Getenv("EXCLUDE_SIZES")),
+		ExcludeNoCamera:   os.Getenv("EXCLUDE_NO_CAMERA") == "true",
+		ExcludeTags:       parseList(os.Getenv("EXCLUDE_TAGS")),
+		BurstSeconds:      burstSeconds,
+		SkipDuplicates:    os.Getenv("SKIP_DUPLICATES") == "true",
+		PerceptualHash:    os.Getenv("PERCEPTUAL_HASH") == "true",
+		IndexMode:         os.Getenv("INDEX_MODE") == "true",
+		ShuffleSeed:       shuffleSeed,
+		FreshDays:         freshDays,
+		FreshPercent:      freshPercent,
 	}
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateRange is a window of capture dates: After is inclusive, Before
+// exclusive, and a zero end is open. Dates are local midnights, so a year
+// means the year as it was on the camera's side of the world.
+type dateRange struct {
+	After  time.Time
+	Before time.Time
+}
+
+// parseDateRange reads "<from>..<to>", where each end is a year (2015) or a day
+// (2015-06-01) and may be left out: "2015.." is 2015 to now, "..2019" is up to
+// the end of 2019. Both ends are inclusive.
+func parseDateRange(v string) (dateRange, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(v), "..")
+	if !ok {
+		return dateRange{}, fmt.Errorf("date range %q: use <from>..<to>", v)
+	}
+	var r dateRange
+	var err error
+	if from = strings.TrimSpace(from); from != "" {
+		if r.After, err = parseRangeEnd(from, false); err != nil {
+			return dateRange{}, err
+		}
+	}
+	if to = strings.TrimSpace(to); to != "" {
+		if r.Before, err = parseRangeEnd(to, true); err != nil {
+			return dateRange{}, err
+		}
+	}
+	if r.empty() {
+		return dateRange{}, fmt.Errorf("date range %q ends before it starts", v)
+	}
+	return r, nil
+}
+
+// parseRangeEnd reads one end of a range. The end of a range is exclusive, so
+// "2019" as an end is the start of 2020.
+func parseRangeEnd(v string, end bool) (time.Time, error) {
+	if year, err := strconv.Atoi(v); err == nil && len(v) == 4 {
+		if end {
+			year++
+		}
+		return time.Date(year, 1, 1, 0, 0, 0, 0, time.Local), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %q: use a year (2015) or a day (2015-06-01)", v)
+	}
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// UnmarshalJSON lets a frame profile give its range as the same string
+// DATE_RANGE takes.
+func (r *dateRange) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	parsed, err := parseDateRange(v)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r dateRange) empty() bool {
+	return !r.After.IsZero() && !r.Before.IsZero() && !r.After.Before(r.Before)
+}
+
+// intersect returns the part of r that also lies in o.
+func (r dateRange) intersect(o dateRange) dateRange {
+	if o.After.After(r.After) {
+		r.After = o.After
+	}
+	if !o.Before.IsZero() && (r.Before.IsZero() || o.Before.Before(r.Before)) {
+		r.Before = o.Before
+	}
+	return r
+}
+
+// String formats the range for keys and logs, as local days with the end
+// exclusive: "2015-01-01..2020-01-01", or with an open end left blank.
+func (r dateRange) String() string {
+	var from, to string
+	if !r.After.IsZero() {
+		from = r.After.Format("2006-01-02")
+	}
+	if !r.Before.IsZero() {
+		to = r.Before.Format("2006-01-02")
+	}
+	return from + ".." + to
+}
+
+// without splits a range around the excluded years. Immich takes one
+// takenAfter/takenBefore window per search, so each piece becomes a source of
+// its own.
+func (r dateRange) without(years []int) []dateRange {
+	years = append([]int(nil), years...)
+	sort.Ints(years)
+	var out []dateRange
+	for _, y := range years {
+		start := time.Date(y, 1, 1, 0, 0, 0, 0, time.Local)
+		end := time.Date(y+1, 1, 1, 0, 0, 0, 0, time.Local)
+		if !r.Before.IsZero() && !start.Before(r.Before) {
+			break
+		}
+		if !end.After(r.After) {
+			continue
+		}
+		if r.After.IsZero() || r.After.Before(start) {
+			out = append(out, dateRange{After: r.After, Before: start})
+		}
+		r.After = end
+	}
+	if !r.empty() {
+		out = append(out, r)
+	}
+	return out
+}
+
+// splitDateRange takes a per-source range off a DEVICE_MODELS or ALBUMS
+// entry, as in "Frame@2015..2019". An entry without one is returned whole.
+func splitDateRange(entry string) (string, dateRange, bool, error) {
+	i := strings.LastIndex(entry, "@")
+	if i < 0 || !strings.Contains(entry[i+1:], "..") {
+		return entry, dateRange{}, false, nil
+	}
+	r, err := parseDateRange(entry[i+1:])
+	return strings.TrimSpace(entry[:i]), r, true, err
+}
+
+// withDates splits a source into one per window of the global DATE_RANGE and
+// EXCLUDE_YEARS, narrowed further by its own range if it has one. A source
+// whose range lies entirely outside them is left out.
+func (c *PhotoCache) withDates(src Source, own dateRange) []Source {
+	r := own.intersect(c.cfg.DateRange)
+	if r.empty() {
+		log.Printf("Source %q has no dates left inside DATE_RANGE, skipping", src.Name)
+		return nil
+	}
+	windows := r.without(c.cfg.ExcludeYears)
+	if len(windows) == 1 && windows[0] == (dateRange{}) {
+		return []Source{src}
+	}
+	var out []Source
+	for _, w := range windows {
+		s := src
+		s.TakenAfter = w.After
+		s.TakenBefore = w.Before
+		s.Name = fmt.Sprintf("%s (%s)", src.Name, w)
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"2015..", "2015-01-01.."},
+		{"..2019", "..2020-01-01"},
+		{"2015..2019", "2015-01-01..2020-01-01"},
+		{"2018-06-01..2018-06-30", "2018-06-01..2018-07-01"},
+	}
+	for _, tt := range tests {
+		r, err := parseDateRange(tt.in)
+		if err != nil {
+			t.Errorf("parseDateRange(%q): %v", tt.in, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("parseDateRange(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"2015", "2019..2015", "last year..", "2015-13-01.."} {
+		if _, err := parseDateRange(bad); err == nil {
+			t.Errorf("parseDateRange(%q) accepted", bad)
+		}
+	}
+}
+
+func TestDateRangeWithout(t *testing.T) {
+	r, _ := parseDateRange("2010..2022")
+	var got []string
+	for _, w := range r.without([]int{2021, 2012, 2013, 2030}) {
+		got = append(got, w.String())
+	}
+	want := []string{"2010-01-01..2012-01-01", "2014-01-01..2021-01-01", "2022-01-01..2023-01-01"}
+	if len(got) != len(want) {
+		t.Fatalf("without = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("without = %v, want %v", got, want)
+			break
+		}
+	}
+
+	open := dateRange{}.without([]int{2020})
+	if len(open) != 2 || open[0].String() != "..2020-01-01" || open[1].String() != "2021-01-01.." {
+		t.Errorf("open range without 2020 = %v", open)
+	}
+}
+
+func TestSplitDateRange(t *testing.T) {
+	if name, r, ok, err := splitDateRange("Frame@2015..2019"); err != nil || !ok || name != "Frame" || r.String() != "2015-01-01..2020-01-01" {
+		t.Errorf("splitDateRange(Frame@2015..2019) = %q, %v, %v, %v", name, r, ok, err)
+	}
+	if name, _, ok, _ := splitDateRange("me@home"); ok || name != "me@home" {
+		t.Errorf("splitDateRange(me@home) = %q, %v; want the name untouched", name, ok)
+	}
+}
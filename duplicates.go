@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+	"time"
+)
+
+// recentSize is how many of the last shown photos near-duplicates are checked
+// against: a few hours of slideshow at the usual intervals.
+const recentSize = 500
+
+// hashDistance is how many of the 64 bits two perceptual hashes may differ in
+// and still count as the same picture.
+const hashDistance = 6
+
+// nearDuplicate reports whether a photo is too much like one shown recently or
+// already queued: taken within BURST_SECONDS of it on the same device, in the
+// same Immich duplicate group (SKIP_DUPLICATES), or with a close perceptual
+// hash (PERCEPTUAL_HASH). Caller must hold c.mu.
+func (c *PhotoCache) nearDuplicate(p PhotoInfo) bool {
+	for _, others := range [][]PhotoInfo{c.recent, c.queue} {
+		for _, o := range others {
+			if o.ID != p.ID && c.alike(p, o) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *PhotoCache) alike(a, b PhotoInfo) bool {
+	if c.cfg.BurstSeconds > 0 && !a.takenAt.IsZero() && !b.takenAt.IsZero() && a.device == b.device {
+		gap := a.takenAt.Sub(b.takenAt)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= time.Duration(c.cfg.BurstSeconds)*time.Second {
+			return true
+		}
+	}
+	if c.cfg.SkipDuplicates && a.duplicateID != "" && a.duplicateID == b.duplicateID {
+		return true
+	}
+	if c.cfg.PerceptualHash && a.hashed && b.hashed && bits.OnesCount64(a.hash^b.hash) <= hashDistance {
+		return true
+	}
+	return false
+}
+
+// addRecent records a shown photo in the rolling window. Caller must hold c.mu.
+func (c *PhotoCache) addRecent(p PhotoInfo) {
+	if c.cfg.BurstSeconds == 0 && !c.cfg.SkipDuplicates && !c.cfg.PerceptualHash {
+		return
+	}
+	c.recent = append(c.recent, p)
+	if len(c.recent) > recentSize {
+		c.recent = c.recent[len(c.recent)-recentSize:]
+	}
+}
+
+// dHash computes a 64-bit difference hash of an image: shrunk to 9×8 grey
+// pixels, each bit says whether a pixel is brighter than its right-hand
+// neighbour. Resized copies and re-encodes of a picture, and most burst
+// neighbours, land within a few bits of each other.
+func dHash(data []byte) (uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	small := resample(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if grey(small, x, y) > grey(small, x+1, y) {
+				hash |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return hash, nil
+}
+
+func grey(img *image.RGBA, x, y int) int {
+	p := img.Pix[y*img.Stride+x*4:]
+	return 299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+func TestBurstShotsAreNotQueuedTogether(t *testing.T) {
+	// Every fake asset was taken at the same instant on the same device.
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 5})
+	c.cfg.BurstSeconds = 10
+	c.refreshTotal()
+
+	for c.fillQueue() {
+	}
+	if len(c.queue) != 1 {
+		t.Fatalf("queued %d photos from one burst, want 1", len(c.queue))
+	}
+	c.next()
+	if c.fillQueue() {
+		t.Errorf("queued another burst shot right after showing one")
+	}
+}
+
+func TestDHashMatchesResizedCopies(t *testing.T) {
+	encode := func(w, h int, pattern func(x, y int) uint8) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				v := pattern(x*640/w, y*480/h)
+				img.Set(x, y, color.RGBA{v, v, v, 255})
+			}
+		}
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, nil)
+		return buf.Bytes()
+	}
+	blobs := func(x, y int) uint8 { return uint8(128 + 100*math.Sin(float64(x)/70)*math.Cos(float64(y)/50)) }
+	stripes := func(x, y int) uint8 { return uint8((y / 40 % 2) * 200) }
+
+	a, _ := dHash(encode(640, 480, blobs))
+	b, _ := dHash(encode(320, 240, blobs))
+	other, _ := dHash(encode(640, 480, stripes))
+
+	if d := bits.OnesCount64(a ^ b); d > hashDistance {
+		t.Errorf("resized copy differs by %d bits, want at most %d", d, hashDistance)
+	}
+	if d := bits.OnesCount64(a ^ other); d <= hashDistance {
+		t.Errorf("different picture differs by only %d bits", d)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"immich-ipad/immich"
+)
+
+// screenSizes are the screen resolutions, in pixels, of common phones and
+// tablets. An image exactly one of these sizes is almost always a screenshot
+// or a screen-sized app export. "screens" in EXCLUDE_SIZES stands for them all.
+var screenSizes = []string{
+	"640x1136", "750x1334", "828x1792", "1080x1920", "1125x2436", "1170x2532",
+	"1179x2556", "1242x2208", "1242x2688", "1284x2778", "1290x2796",
+	"1536x2048", "1620x2160", "1640x2360", "1668x2224", "1668x2388", "2048x2732",
+	"720x1280", "1080x2340", "1080x2400", "1440x3200",
+}
+
+// exclusionRules decide which assets never make it into the rotation. They are
+// applied in fetchPage, so an excluded asset is never queued, counted as a
+// memory or shown.
+type exclusionRules struct {
+	names    []string // lowercase globs matched against the file name
+	mimes    map[string]bool
+	sizes    map[[2]int]bool // width x height, in either orientation
+	noCamera bool
+	// tagged holds the assets carrying one of EXCLUDE_TAGS, refreshed with the
+	// page counts.
+	tagged map[string]bool
+}
+
+func newExclusionRules(cfg Config) exclusionRules {
+	r := exclusionRules{
+		mimes:    make(map[string]bool),
+		sizes:    make(map[[2]int]bool),
+		noCamera: cfg.ExcludeNoCamera,
+	}
+	for _, n := range cfg.ExcludeNames {
+		r.names = append(r.names, strings.ToLower(n))
+	}
+	for _, m := range cfg.ExcludeMIMETypes {
+		r.mimes[strings.ToLower(m)] = true
+	}
+	for _, s := range cfg.ExcludeSizes {
+		if s == "screens" {
+			for _, s := range screenSizes {
+				r.addSize(s)
+			}
+			continue
+		}
+		if !r.addSize(s) {
+			log.Printf("Ignoring EXCLUDE_SIZES entry %q: use <width>x<height>", s)
+		}
+	}
+	return r
+}
+
+func (r exclusionRules) addSize(s string) bool {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil {
+		return false
+	}
+	r.sizes[[2]int{width, height}] = true
+	r.sizes[[2]int{height, width}] = true
+	return true
+}
+
+// needsExif reports whether the rules look at EXIF, which the search then has
+// to include.
+func (r exclusionRules) needsExif() bool {
+	return len(r.sizes) > 0 || r.noCamera
+}
+
+// excludes reports whether an asset is junk, and which rule says so.
+func (r exclusionRules) excludes(a immich.Asset) (string, bool) {
+	name := strings.ToLower(a.OriginalFileName)
+	for _, pattern := range r.names {
+		if ok, _ := path.Match(pattern, name); ok {
+			return "file name " + pattern, true
+		}
+	}
+	if r.mimes[strings.ToLower(a.OriginalMimeType)] {
+		return "type " + a.OriginalMimeType, true
+	}
+	if r.tagged[a.ID] {
+		return "tag", true
+	}
+	if a.ExifInfo == nil {
+		return "", false
+	}
+	if r.sizes[[2]int{a.ExifInfo.ExifImageWidth, a.ExifInfo.ExifImageHeight}] {
+		return fmt.Sprintf("size %dx%d", a.ExifInfo.ExifImageWidth, a.ExifInfo.ExifImageHeight), true
+	}
+	if r.noCamera && a.ExifInfo.Make == "" && a.ExifInfo.Model == "" {
+		return "no camera", true
+	}
+	return "", false
+}
+
+// refreshExcludedTags reloads the assets carrying one of EXCLUDE_TAGS. A tag
+// that does not exist is skipped; a failed lookup keeps the previous set.
+func (c *PhotoCache) refreshExcludedTags() {
+	if len(c.cfg.ExcludeTags) == 0 {
+		return
+	}
+	tagged := make(map[string]bool)
+	for _, name := range c.cfg.ExcludeTags {
+		id, err := findTag(c.api, name, false)
+		if err != nil {
+			log.Printf("Excluded tag lookup failed, keeping previous: %v", err)
+			return
+		}
+		if id == "" {
+			log.Printf("Excluded tag %q not found in Immich", name)
+			continue
+		}
+		ids, err := taggedAssets(c.api, id)
+		if err != nil {
+			log.Printf("Excluded tag lookup failed, keeping previous: %v", err)
+			return
+		}
+		for id := range ids {
+			tagged[id] = true
+		}
+	}
+	c.mu.Lock()
+	c.exclude.tagged = tagged
+	c.mu.Unlock()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"immich-ipad/immich"
+)
+
+func TestExclusionRules(t *testing.T) {
+	rules := newExclusionRules(Config{
+		ExcludeNames:     []string{"*screenshot*", "IMG-*-WA*"},
+		ExcludeMIMETypes: []string{"image/png"},
+		ExcludeSizes:     []string{"screens", "800x600"},
+		ExcludeNoCamera:  true,
+	})
+	if !rules.needsExif() {
+		t.Error("size and camera rules need EXIF, but needsExif() = false")
+	}
+
+	camera := func(w, h int, make, model string) immich.Asset {
+		a := immich.Asset{OriginalFileName: "IMG_0001.HEIC", OriginalMimeType: "image/heic"}
+		a.ExifInfo = &immich.Exif{ExifImageWidth: w, ExifImageHeight: h, Make: make, Model: model}
+		return a
+	}
+
+	tests := []struct {
+		name  string
+		asset immich.Asset
+		want  bool
+	}{
+		{"photo", camera(4032, 3024, "Apple", "iPhone XS"), false},
+		{"screenshot", immich.Asset{OriginalFileName: "Screenshot 2024-01-01.PNG"}, true},
+		{"whatsapp", immich.Asset{OriginalFileName: "IMG-20240101-WA0003.jpg"}, true},
+		{"png", immich.Asset{OriginalFileName: "export.png", OriginalMimeType: "image/png"}, true},
+		{"phone screen", camera(1170, 2532, "Apple", "iPhone 12"), true},
+		{"landscape screen", camera(2532, 1170, "Apple", "iPhone 12"), true},
+		{"listed size", camera(600, 800, "Canon", "EOS"), true},
+		{"no camera", camera(4000, 3000, "", ""), true},
+		{"no exif", immich.Asset{OriginalFileName: "IMG_0002.JPG"}, false},
+	}
+	for _, tt := range tests {
+		if reason, got := rules.excludes(tt.asset); got != tt.want {
+			t.Errorf("%s: excludes = %v (%s), want %v", tt.name, got, reason, tt.want)
+		}
+	}
+}
+
+func TestExcludedTagsAreSkipped(t *testing.T) {
+	c, fake := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 3})
+	fake.Tag("frame-hidden", "p2")
+	c.cfg.ExcludeTags = []string{"frame-hidden"}
+	c.refreshTotal()
+
+	if photos, raw, _ := c.fetchPage(c.sources[0], 2, 1); len(photos) != 0 || raw != 1 {
+		t.Errorf("fetchPage(2) = %d photos (%d raw), want the tagged photo skipped but counted", len(photos), raw)
+	}
+	if photos, _, _ := c.fetchPage(c.sources[0], 1, 1); len(photos) != 1 {
+		t.Errorf("fetchPage(1) = %d photos, want the untagged photo", len(photos))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifJPEGHeader builds the start of a JPEG whose EXIF block holds only an
+// orientation tag, in big-endian TIFF order.
+func exifJPEGHeader(orientation byte) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // header, IFD0 at offset 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, // orientation, SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	n := len(segment) + 2
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(n >> 8), byte(n)}
+	data = append(data, segment...)
+	return append(data, 0xFF, 0xDA, 0, 2)
+}
+
+func TestReadOrientation(t *testing.T) {
+	for _, o := range []byte{1, 3, 6, 8} {
+		if got := readOrientation(exifJPEGHeader(o)); got != int(o) {
+			t.Errorf("orientation %d read as %d", o, got)
+		}
+	}
+	if got := readOrientation([]byte("not a jpeg")); got != 1 {
+		t.Errorf("non-JPEG read as orientation %d, want 1", got)
+	}
+}
+
+func TestApplyOrientationRotatesClockwise(t *testing.T) {
+	// A 2x1 image: red on the left, blue on the right.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	// Orientation 6 is stored rotated counter-clockwise, so turning it upright
+	// puts the left (red) pixel on top.
+	out := applyOrientation(src, 6)
+	if b := out.Bounds(); b.Dx() != 1 || b.Dy() != 2 {
+		t.Fatalf("rotated bounds = %v, want 1x2", b)
+	}
+	if out.At(0, 0) != red || out.At(0, 1) != blue {
+		t.Errorf("got top %v bottom %v, want red over blue", out.At(0, 0), out.At(0, 1))
+	}
+}
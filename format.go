@@ -8,20 +8,23 @@ import (
 type PhotoInfo struct {
 	ID       string  `json:"id"`
 	Date     string  `json:"date"`
+	Memory   string  `json:"memory,omitempty"`
 	City     string  `json:"city"`
 	Lat      float64 `json:"lat,omitempty"`
 	Lon      float64 `json:"lon,omitempty"`
 	Index    int     `json:"index"`
 	Total    int     `json:"total"`
 	cityDone bool
+	// takenAt, device and duplicateID come from the search, and hash from
+	// the enrich hook; they are what near-duplicates are spotted by.
+	takenAt     time.Time
+	device      string
+	duplicateID string
+	hash        uint64
+	hashed      bool
 }
 
-var turkishMonths = []string{
-	"Ocak", "Şubat", "Mart", "Nisan", "Mayıs", "Haziran",
-	"Temmuz", "Ağustos", "Eylül", "Ekim", "Kasım", "Aralık",
-}
-
-func formatDate(isoDate string) string {
+func formatDate(isoDate string, loc *locale) string {
 	t, err := time.Parse(time.RFC3339Nano, isoDate)
 	if err != nil {
 		t, err = time.Parse("2006-01-02T15:04:05.000Z", isoDate)
@@ -29,5 +32,13 @@ func formatDate(isoDate string) string {
 			return ""
 		}
 	}
-	return fmt.Sprintf("%d %s %d", t.Day(), turkishMonths[t.Month()-1], t.Year())
+	return fmt.Sprintf(loc.DateFormat, t.Day(), loc.Months[t.Month()-1], t.Year())
+}
+
+// formatYearsAgo describes how long ago an "on this day" photo was taken.
+func formatYearsAgo(years int, loc *locale) string {
+	if years == 1 {
+		return loc.YearAgo
+	}
+	return fmt.Sprintf(loc.YearsAgo, years)
 }
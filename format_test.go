@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestFormatDateLocales(t *testing.T) {
+	cases := map[string]string{
+		"tr":    "2 Mart 2024",
+		"en-US": "March 2, 2024",
+		"de":    "2. März 2024",
+		"xx":    "2 Mart 2024",
+	}
+	for name, want := range cases {
+		if got := formatDate("2024-03-02T10:00:00.000Z", localeFor(name)); got != want {
+			t.Errorf("%s: formatDate = %q, want %q", name, got, want)
+		}
+	}
+	if got := formatYearsAgo(3, localeFor("de")); got != "vor 3 Jahren" {
+		t.Errorf("formatYearsAgo(3, de) = %q", got)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"immich-ipad/immich"
+)
+
+// Frame is one display the server drives: the default frame configured from
+// the environment, or a named profile from FRAMES_FILE selected with
+// ?frame=<name>. Each frame has its own rotation, so two iPads never take
+// photos out of each other's queue.
+type Frame struct {
+	Name   string
+	cfg    Config
+	cache  *PhotoCache
+	remote *remote
+}
+
+func newFrame(name string, cfg Config, api *immich.Client) *Frame {
+	return &Frame{
+		Name:   name,
+		cfg:    cfg,
+		cache:  newPhotoCache(cfg, api),
+		remote: newRemote(),
+	}
+}
+
+// frameProfile is one entry in FRAMES_FILE. Every field is optional; unset
+// fields fall back to the environment configuration.
+type frameProfile struct {
+	DeviceModels      []string      `json:"deviceModels"`
+	Albums            []string      `json:"albums"`
+	People            []string      `json:"people"`
+	PeopleMode        *string       `json:"peopleMode"`
+	SlideshowInterval *int          `json:"slideshowInterval"`
+	ShowMap           *bool         `json:"showMap"`
+	ShowWeather       *bool         `json:"showWeather"`
+	ShowForecast      *bool         `json:"showForecast"`
+	WeatherLat        *string       `json:"weatherLat"`
+	WeatherLon        *string       `json:"weatherLon"`
+	Locale            *string       `json:"locale"`
+	Units             *string       `json:"units"`
+	MemoriesPercent   *int          `json:"memoriesPercent"`
+	ScreenWidth       *int          `json:"screenWidth"`
+	ScreenHeight      *int          `json:"screenHeight"`
+	JPEGQuality       *int          `json:"jpegQuality"`
+	Selection         selectionList `json:"selection"`
+	DateRange         *dateRange    `json:"dateRange"`
+	ExcludeYears      []int         `json:"excludeYears"`
+	IndexMode         *bool         `json:"indexMode"`
+	FreshDays         *int          `json:"freshDays"`
+	FreshPercent      *int          `json:"freshPercent"`
+}
+
+// apply returns base with the profile's settings laid over it. Sources are
+// replaced as a group: a profile that names only albums must not inherit the
+// default device models too.
+func (p frameProfile) apply(name string, base Config) Config {
+	cfg := base
+	if len(p.DeviceModels) > 0 || len(p.Albums) > 0 {
+		cfg.DeviceModels = p.DeviceModels
+		cfg.Albums = p.Albums
+	}
+	if len(p.People) > 0 {
+		cfg.People = p.People
+	}
+	if p.PeopleMode != nil {
+		cfg.PeopleMode = *p.PeopleMode
+	}
+	if p.SlideshowInterval != nil && *p.SlideshowInterval > 0 {
+		cfg.SlideshowInterval = *p.SlideshowInterval
+	}
+	if p.ShowMap != nil {
+		cfg.ShowMap = *p.ShowMap
+	}
+	if p.ShowWeather != nil {
+		cfg.ShowWeather = *p.ShowWeather
+	}
+	if p.ShowForecast != nil {
+		cfg.ShowForecast = *p.ShowForecast
+	}
+	if p.WeatherLat != nil {
+		cfg.WeatherLat = *p.WeatherLat
+	}
+	if p.WeatherLon != nil {
+		cfg.WeatherLon = *p.WeatherLon
+	}
+	if p.Locale != nil {
+		cfg.Locale = *p.Locale
+		checkLocale(fmt.Sprintf("Frame %q", name), cfg.Locale)
+	}
+	if p.Units != nil {
+		cfg.Units = *p.Units
+	}
+	if p.MemoriesPercent != nil {
+		cfg.MemoriesPercent = *p.MemoriesPercent
+	}
+	if p.ScreenWidth != nil && p.ScreenHeight != nil {
+		cfg.ScreenWidth = *p.ScreenWidth
+		cfg.ScreenHeight = *p.ScreenHeight
+	}
+	if p.JPEGQuality != nil {
+		cfg.JPEGQuality = *p.JPEGQuality
+	}
+	if len(p.Selection) > 0 {
+		cfg.Selection = p.Selection
+	}
+	if p.DateRange != nil {
+		cfg.DateRange = *p.DateRange
+	}
+	if p.ExcludeYears != nil {
+		cfg.ExcludeYears = p.ExcludeYears
+	}
+	if p.IndexMode != nil {
+		cfg.IndexMode = *p.IndexMode
+	}
+	if p.FreshDays != nil {
+		cfg.FreshDays = *p.FreshDays
+	}
+	if p.FreshPercent != nil {
+		cfg.FreshPercent = *p.FreshPercent
+	}
+	// Each frame keeps its own shown set and page counts.
+	if cfg.StateDir != "" {
+		cfg.StateDir = filepath.Join(cfg.StateDir, "frames", name)
+	}
+	return cfg
+}
+
+// frameNamePattern keeps profile names safe to use in URLs and state paths.
+var frameNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// loadFrames builds the default frame plus one per profile in FRAMES_FILE, a
+// JSON object mapping frame names to profiles:
+//
+//	{"kitchen": {"albums": ["Frame"], "slideshowInterval": 30, "showMap": true}}
+func loadFrames(cfg Config, api *immich.Client) (map[string]*Frame, error) {
+	frames := map[string]*Frame{"": newFrame("", cfg, api)}
+	if cfg.FramesFile == "" {
+		return frames, nil
+	}
+
+	data, err := os.ReadFile(cfg.FramesFile)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]frameProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", cfg.FramesFile, err)
+	}
+	for name, p := range profiles {
+		if !frameNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("frame name %q: use lowercase letters, digits, '-' and '_'", name)
+		}
+		frames[name] = newFrame(name, p.apply(name, cfg), api)
+	}
+	return frames, nil
+}
+
+// frame returns the frame a request is for, from its ?frame= parameter. An
+// unknown name gets a 404 rather than silently showing the default rotation.
+func (s *Server) frame(w http.ResponseWriter, r *http.Request) (*Frame, bool) {
+	f, ok := s.frames[r.URL.Query().Get("frame")]
+	if !ok {
+		http.Error(w, "Unknown frame", http.StatusNotFound)
+		return nil, false
+	}
+	return f, true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"immich-ipad/immich"
+)
+
+func TestLoadFramesLaysProfilesOverDefaults(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "frames.json")
+	os.WriteFile(path, []byte(`{"kitchen": {"albums": ["Frame"], "slideshowInterval": 30, "showMap": true}}`), 0o644)
+
+	base := Config{
+		DeviceModels:      []string{"iPhone XS"},
+		SlideshowInterval: 15,
+		ShowWeather:       true,
+		StateDir:          dir,
+		FramesFile:        path,
+	}
+	frames, err := loadFrames(base, immich.New("", "", http.DefaultClient))
+	if err != nil {
+		t.Fatalf("loadFrames: %v", err)
+	}
+
+	kitchen := frames["kitchen"]
+	if kitchen == nil {
+		t.Fatal("kitchen frame missing")
+	}
+	if len(kitchen.cfg.DeviceModels) != 0 || len(kitchen.cfg.Albums) != 1 {
+		t.Errorf("kitchen sources = %v / %v, want only the Frame album", kitchen.cfg.DeviceModels, kitchen.cfg.Albums)
+	}
+	if kitchen.cfg.SlideshowInterval != 30 || !kitchen.cfg.ShowMap || !kitchen.cfg.ShowWeather {
+		t.Errorf("kitchen settings not applied over defaults: %+v", kitchen.cfg)
+	}
+	if kitchen.cache.statePath() == frames[""].cache.statePath() {
+		t.Error("kitchen shares its state file with the default frame")
+	}
+	if frames[""].cfg.SlideshowInterval != 15 {
+		t.Error("default frame picked up the kitchen profile")
+	}
+}
@@ -3,11 +3,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"image"
 	"image/draw"
 	"image/png"
-	"io"
 	"log"
 	"math"
 	"net/http"
@@ -19,22 +17,77 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	f, ok := s.frame(w, r)
+	if !ok {
+		return
+	}
+	loc := localeFor(f.cfg.Locale)
+	tempUnit := "\u00B0C"
+	if f.cfg.Units == "imperial" {
+		tempUnit = "\u00B0F"
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	s.tmpl.Execute(w, map[string]interface{}{
-		"Interval":    s.cfg.SlideshowInterval,
-		"ShowMap":      s.cfg.ShowMap,
-		"ShowWeather":  s.cfg.ShowWeather,
+		"Connecting":   loc.Connecting,
+		"PercentFirst": loc.PercentFirst,
+		"TempUnit":     tempUnit,
+		"Frame":        f.Name,
+		"Interval":     f.cfg.SlideshowInterval,
+		"ShowMap":      f.cfg.ShowMap,
+		"ShowWeather":  f.cfg.ShowWeather,
+		"ShowForecast": f.cfg.ShowForecast,
+		"ReadOnly":     s.cfg.ReadOnly,
 	})
 }
 
+// handleFavorite marks a photo as a favorite in Immich: POST
+// /favorite?id=<asset>, or without an id, whatever the frame is showing.
+// READ_ONLY turns it off.
+func (s *Server) handleFavorite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cfg.ReadOnly {
+		http.Error(w, "Read-only mode", http.StatusForbidden)
+		return
+	}
+	f, ok := s.frame(w, r)
+	if !ok {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		p := f.cache.current()
+		if p == nil {
+			http.Error(w, "Nothing on screen", http.StatusConflict)
+			return
+		}
+		id = p.ID
+	}
+
+	if err := s.api.SetFavorite(r.Context(), id, true); err != nil {
+		log.Printf("Frame %q: favorite %s failed: %v", f.Name, id, err)
+		http.Error(w, "Favorite failed", immichStatus(err))
+		return
+	}
+	log.Printf("Frame %q: marked %s as favorite", f.Name, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *Server) handleRandom(w http.ResponseWriter, r *http.Request) {
-	p := s.cache.next()
+	f, ok := s.frame(w, r)
+	if !ok {
+		return
+	}
+	p := f.cache.next()
 	if p == nil {
 		http.Error(w, "Loading photos...", http.StatusServiceUnavailable)
 		return
 	}
 	if !p.cityDone {
-		loc := s.fetchLocation(p.ID)
+		loc := s.fetchLocation(r.Context(), p.ID)
 		p.City = loc.City
 		p.Lat = loc.Lat
 		p.Lon = loc.Lon
@@ -75,19 +128,9 @@ func (s *Server) handleMap(w http.ResponseWriter, r *http.Request) {
 		for dx := -1; dx <= 1; dx++ {
 			tx := tileX + dx
 			ty := tileY + dy
-			tileURL := fmt.Sprintf("https://tile.openstreetmap.org/%d/%d/%d.png", zoom, tx, ty)
-			req, err := http.NewRequest("GET", tileURL, nil)
-			if err != nil {
-				continue
-			}
-			req.Header.Set("User-Agent", "immich-ipad/1.0")
-			resp, err := s.client.Do(req)
-			if err != nil {
-				continue
-			}
-			tileImg, err := png.Decode(resp.Body)
-			resp.Body.Close()
+			tileImg, err := s.tiles.get(zoom, tx, ty)
 			if err != nil {
+				log.Printf("Map tile %d/%d/%d error: %v", zoom, tx, ty, err)
 				continue
 			}
 			destX := (dx + 1) * 256
@@ -185,42 +228,21 @@ func drawMarker(img *image.RGBA, px, py int) {
 }
 
 func (s *Server) handleWeather(w http.ResponseWriter, r *http.Request) {
-	url := fmt.Sprintf(
-		"https://api.open-meteo.com/v1/forecast?latitude=%s&longitude=%s&current_weather=true",
-		s.cfg.WeatherLat, s.cfg.WeatherLon,
-	)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-		return
-	}
-	resp, err := s.client.Do(req)
-	if err != nil {
-		http.Error(w, "Failed to fetch weather", http.StatusBadGateway)
+	f, ok := s.frame(w, r)
+	if !ok {
 		return
 	}
-	defer resp.Body.Close()
-
-	var data struct {
-		CurrentWeather struct {
-			Temperature float64 `json:"temperature"`
-			WeatherCode int     `json:"weathercode"`
-			IsDay       int     `json:"is_day"`
-		} `json:"current_weather"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		http.Error(w, "Failed to parse weather", http.StatusInternalServerError)
+	data, stale, ok := s.weather.station(weatherLocationFor(f.cfg)).reading()
+	if !ok {
+		http.Error(w, "Weather not available yet", http.StatusServiceUnavailable)
 		return
 	}
-
-	isDay := data.CurrentWeather.IsDay == 1
-	icon := weatherIcon(data.CurrentWeather.WeatherCode, isDay)
-	result := map[string]interface{}{
-		"temp": fmt.Sprintf("%d", int(data.CurrentWeather.Temperature)),
-		"icon": "/weather-icon/" + icon,
-	}
+	result := struct {
+		weatherReport
+		Stale bool `json:"stale"`
+	}{newWeatherReport(data, localeFor(f.cfg.Locale)), stale}
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=900")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	json.NewEncoder(w).Encode(result)
 }
 
@@ -236,91 +258,56 @@ func (s *Server) handleWeatherIcon(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func weatherIcon(code int, isDay bool) string {
-	switch {
-	case code == 0:
-		if isDay {
-			return "sunny.png"
-		}
-		return "clear_night.png"
-	case code == 1:
-		if isDay {
-			return "mostly_sunny.png"
-		}
-		return "mostly_clear_night.png"
-	case code == 2:
-		if isDay {
-			return "partly_cloudy.png"
-		}
-		return "partly_cloudy_night.png"
-	case code == 3:
-		if isDay {
-			return "mostly_cloudy_day.png"
-		}
-		return "mostly_cloudy_night.png"
-	case code == 45 || code == 48:
-		return "haze_fog_dust_smoke.png"
-	case code >= 51 && code <= 55:
-		return "drizzle.png"
-	case code >= 56 && code <= 57:
-		return "sleet_hail.png"
-	case code >= 61 && code <= 63:
-		return "showers_rain.png"
-	case code == 65:
-		return "heavy_rain.png"
-	case code >= 66 && code <= 67:
-		return "wintry_mix_rain_snow.png"
-	case code >= 71 && code <= 75:
-		return "heavy_snow.png"
-	case code == 77:
-		return "flurries.png"
-	case code >= 80 && code <= 82:
-		if isDay {
-			return "scattered_showers_day.png"
-		}
-		return "scattered_showers_night.png"
-	case code >= 85 && code <= 86:
-		return "snow_showers_snow.png"
-	case code == 95:
-		if isDay {
-			return "isolated_scattered_tstorms_day.png"
-		}
-		return "isolated_scattered_tstorms_night.png"
-	case code == 96 || code == 99:
-		return "strong_tstorms.png"
-	default:
-		return "cloudy.png"
-	}
-}
-
 func (s *Server) handlePhoto(w http.ResponseWriter, r *http.Request) {
 	assetID := r.URL.Query().Get("id")
 	if assetID == "" {
 		http.Error(w, "Missing id", http.StatusBadRequest)
 		return
 	}
-
-	thumbnailURL := fmt.Sprintf("%s/api/assets/%s/thumbnail?size=preview", s.cfg.ImmichURL, assetID)
-	req, err := http.NewRequest("GET", thumbnailURL, nil)
-	if err != nil {
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	f, ok := s.frame(w, r)
+	if !ok {
 		return
 	}
-	req.Header.Set("x-api-key", s.cfg.ImmichAPIKey)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	size, resize := photoSizeFor(f.cfg, r.URL.Query())
+	key := photoKey(assetID, size)
+	if resize {
+		if data, ok := s.photos.get(key); ok {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(data)
+			return
+		}
+	}
 
-	resp, err := s.client.Do(req)
+	data, contentType, err := s.fetchThumbnail(r.Context(), assetID)
 	if err != nil {
-		http.Error(w, "Failed to fetch photo", http.StatusBadGateway)
+		log.Printf("Photo fetch error for %s: %v", assetID, err)
+		http.Error(w, "Failed to fetch photo", immichStatus(err))
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "Failed to fetch photo", http.StatusBadGateway)
-		return
+	// Old Safari ignores EXIF orientation, so a rotated preview has to be
+	// re-encoded upright even when no resizing was asked for.
+	if !resize && readOrientation(data) > 1 {
+		size = photoSize{Width: maxPhotoSide, Height: maxPhotoSide, Quality: f.cfg.JPEGQuality}
+		key = photoKey(assetID, size)
+		resize = true
 	}
 
-	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	io.Copy(w, resp.Body)
+	if resize {
+		resized, err := resizePhoto(data, size)
+		if err == nil {
+			s.photos.add(key, resized)
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(resized)
+			return
+		}
+		// Immich can be set to generate WebP previews, which the standard
+		// library cannot decode; the original is still better than nothing.
+		log.Printf("Photo resize error for %s, serving preview as is: %v", assetID, err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
 }
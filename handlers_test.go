@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"immich-ipad/immich/immichtest"
+)
+
+func TestFavoriteMarksPhotoInImmich(t *testing.T) {
+	fake := immichtest.NewServer(nil)
+	t.Cleanup(fake.Close)
+	newServer := func(cfg Config) (*Server, *Frame) {
+		f := newFrame("", cfg, fake.Client())
+		return &Server{cfg: cfg, api: fake.Client(), frames: map[string]*Frame{"": f}}, f
+	}
+	onScreen := func(f *Frame, id string) {
+		f.cache.mu.Lock()
+		defer f.cache.mu.Unlock()
+		f.cache.remember(PhotoInfo{ID: id})
+	}
+	favorite := func(s *Server, method, query string) int {
+		rec := httptest.NewRecorder()
+		s.handleFavorite(rec, httptest.NewRequest(method, "/favorite"+query, nil))
+		return rec.Code
+	}
+
+	s, f := newServer(Config{})
+	if code := favorite(s, "POST", ""); code != http.StatusConflict {
+		t.Errorf("favorite with nothing on screen = %d, want 409", code)
+	}
+	if code := favorite(s, "GET", "?id=p7"); code != http.StatusMethodNotAllowed || fake.Favorite("p7") {
+		t.Errorf("GET favorite = %d, want 405 and no change", code)
+	}
+	if code := favorite(s, "POST", "?id=p7"); code != http.StatusNoContent || !fake.Favorite("p7") {
+		t.Errorf("favorite p7 = %d, favorite in Immich: %v", code, fake.Favorite("p7"))
+	}
+
+	// Without an id it is the photo on screen.
+	onScreen(f, "p3")
+	if code := favorite(s, "POST", ""); code != http.StatusNoContent || !fake.Favorite("p3") {
+		t.Errorf("favorite on screen = %d, p3 favorite in Immich: %v", code, fake.Favorite("p3"))
+	}
+
+	readOnly, f := newServer(Config{ReadOnly: true})
+	onScreen(f, "p5")
+	if code := favorite(readOnly, "POST", ""); code != http.StatusForbidden || fake.Favorite("p5") {
+		t.Errorf("favorite in READ_ONLY = %d, p5 favorite in Immich: %v; want 403 and untouched", code, fake.Favorite("p5"))
+	}
+}
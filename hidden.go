@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"immich-ipad/immich"
+)
+
+// hiddenList is the set of assets that must never be shown again, on any
+// frame. It is kept in STATE_DIR/hidden.json. With HIDE_TAG set it is also
+// mirrored to an Immich tag: hidden assets get tagged, and removing the tag in
+// Immich brings an asset back. READ_ONLY still reads the tag but never writes
+// it.
+type hiddenList struct {
+	mu sync.Mutex
+	// ids maps each hidden asset to whether it is known to carry the tag.
+	ids  map[string]bool
+	path string
+	// syncMu keeps tag syncs from overlapping, so a sync never mistakes an
+	// asset another sync has just tagged for one untagged in Immich.
+	syncMu sync.Mutex
+	api    *immich.Client
+	cfg    Config
+}
+
+func newHiddenList(cfg Config, api *immich.Client) *hiddenList {
+	h := &hiddenList{ids: make(map[string]bool), api: api, cfg: cfg}
+	if cfg.StateDir != "" {
+		h.path = filepath.Join(cfg.StateDir, "hidden.json")
+	}
+	return h
+}
+
+// has reports whether an asset is hidden. A nil list hides nothing.
+func (h *hiddenList) has(id string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.ids[id]
+	return ok
+}
+
+// hide adds an asset to the list and saves it. Tagging happens in the
+// background; a failure there is retried by the next sync.
+func (h *hiddenList) hide(id string) error {
+	h.mu.Lock()
+	if _, ok := h.ids[id]; !ok {
+		h.ids[id] = false
+	}
+	h.mu.Unlock()
+	if h.cfg.HideTag != "" {
+		go func() {
+			if err := h.sync(); err != nil {
+				log.Printf("Hidden tag sync error: %v", err)
+			}
+		}()
+	}
+	return h.save()
+}
+
+type hiddenState struct {
+	Assets map[string]bool `json:"assets"`
+}
+
+// load restores the list saved by save. A missing file is an empty list.
+func (h *hiddenList) load() error {
+	if h.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st hiddenState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, tagged := range st.Assets {
+		h.ids[id] = tagged
+	}
+	log.Printf("Restored %d hidden photos from %s", len(st.Assets), h.path)
+	return nil
+}
+
+// save writes the list the same way saveState does, with writeFileAtomic.
+func (h *hiddenList) save() error {
+	if h.path == "" {
+		return nil
+	}
+	h.mu.Lock()
+	st := hiddenState{Assets: make(map[string]bool, len(h.ids))}
+	for id, tagged := range h.ids {
+		st.Assets[id] = tagged
+	}
+	h.mu.Unlock()
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.path, data)
+}
+
+// startSyncLoop reconciles the list with the Immich tag every hour, picking up
+// tags added or removed in Immich.
+func (h *hiddenList) startSyncLoop() {
+	if h.cfg.HideTag == "" {
+		return
+	}
+	go func() {
+		for {
+			if err := h.sync(); err != nil {
+				log.Printf("Hidden tag sync error: %v", err)
+			}
+			time.Sleep(1 * time.Hour)
+		}
+	}()
+}
+
+// sync makes the list and the Immich tag agree. Assets tagged in Immich are
+// hidden; assets the list knows were tagged but no longer are have been
+// unhidden in Immich and are dropped; assets hidden here but not yet tagged
+// get tagged.
+func (h *hiddenList) sync() error {
+	h.syncMu.Lock()
+	defer h.syncMu.Unlock()
+
+	tagID, err := findTag(h.api, h.cfg.HideTag, !h.cfg.ReadOnly)
+	if err != nil {
+		return err
+	}
+	if tagID == "" {
+		// Read-only, and nothing has been tagged yet.
+		return nil
+	}
+	tagged, err := taggedAssets(h.api, tagID)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	var untagged []string
+	for id, wasTagged := range h.ids {
+		switch {
+		case tagged[id]:
+			h.ids[id] = true
+		case wasTagged:
+			delete(h.ids, id)
+		default:
+			untagged = append(untagged, id)
+		}
+	}
+	for id := range tagged {
+		h.ids[id] = true
+	}
+	h.mu.Unlock()
+
+	if len(untagged) > 0 && !h.cfg.ReadOnly {
+		if err := h.api.TagAssets(context.Background(), tagID, untagged); err != nil {
+			return fmt.Errorf("tag hidden assets: %w", err)
+		}
+		h.mu.Lock()
+		for _, id := range untagged {
+			if _, ok := h.ids[id]; ok {
+				h.ids[id] = true
+			}
+		}
+		h.mu.Unlock()
+		log.Printf("Tagged %d hidden photos %q in Immich", len(untagged), h.cfg.HideTag)
+	}
+	return h.save()
+}
+
+// findTag looks an Immich tag up by name. A missing tag is created if create
+// is set, and otherwise gives "".
+func findTag(api *immich.Client, name string, create bool) (string, error) {
+	tags, err := api.Tags(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("list tags: %w", err)
+	}
+	for _, t := range tags {
+		if t.Value == name || t.Name == name {
+			return t.ID, nil
+		}
+	}
+	if !create {
+		return "", nil
+	}
+
+	created, err := api.CreateTag(context.Background(), name)
+	if err != nil {
+		return "", fmt.Errorf("create tag %q: %w", name, err)
+	}
+	log.Printf("Created Immich tag %q", name)
+	return created.ID, nil
+}
+
+// taggedAssets returns every asset carrying a tag.
+func taggedAssets(api *immich.Client, tagID string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	for page := 1; ; page++ {
+		result, err := api.SearchMetadata(context.Background(), immich.MetadataQuery{TagIDs: []string{tagID}, Page: page, Size: 1000})
+		if err != nil {
+			return nil, fmt.Errorf("search tagged assets: %w", err)
+		}
+		for _, a := range result.Items {
+			ids[a.ID] = true
+		}
+		if result.NextPage == "" || len(result.Items) == 0 {
+			return ids, nil
+		}
+	}
+}
+
+// handleHide hides a photo for good: POST /hide?id=<asset>. It leaves every
+// frame's queue and history at once, and a frame showing it moves on.
+func (s *Server) handleHide(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.hidden.hide(id); err != nil {
+		log.Printf("Hidden list save error: %v", err)
+	}
+	for _, f := range s.frames {
+		if f.cache.drop(id) {
+			f.remote.send("next", nil)
+		}
+	}
+	log.Printf("Hid photo %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
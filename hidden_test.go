@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"immich-ipad/immich/immichtest"
+)
+
+func TestHiddenListSyncsWithTag(t *testing.T) {
+	fake := immichtest.NewServer(nil)
+	t.Cleanup(fake.Close)
+	fake.Tag("frame-hidden", "b")
+
+	h := newHiddenList(Config{ImmichURL: fake.URL, HideTag: "frame-hidden", StateDir: t.TempDir()}, fake.Client())
+	h.ids["a"] = false
+	if err := h.sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if !fake.Tagged("frame-hidden", "a") {
+		t.Errorf("locally hidden asset was not tagged in Immich")
+	}
+	if !h.has("b") {
+		t.Errorf("asset tagged in Immich is not hidden")
+	}
+
+	// Removing the tag in Immich unhides the asset.
+	fake.Untag("frame-hidden", "a")
+	if err := h.sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if h.has("a") {
+		t.Errorf("asset untagged in Immich is still hidden")
+	}
+
+	restored := newHiddenList(h.cfg, nil)
+	if err := restored.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !restored.has("b") || restored.has("a") {
+		t.Errorf("restored list = %v, want only b", restored.ids)
+	}
+}
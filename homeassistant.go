@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// homeAssistant reads a Home Assistant weather entity, for households that
+// already have a local station or a paid forecast integrated there. The entity
+// is the location, so the frame's coordinates are not used; temperatures are
+// converted if Home Assistant's unit differs from the frame's.
+type homeAssistant struct {
+	client  *http.Client
+	baseURL string
+	token   string
+	entity  string
+}
+
+type haState struct {
+	State      string          `json:"state"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+type haForecast struct {
+	Datetime                 string   `json:"datetime"`
+	Condition                string   `json:"condition"`
+	Temperature              float64  `json:"temperature"`
+	TempLow                  *float64 `json:"templow"`
+	PrecipitationProbability *float64 `json:"precipitation_probability"`
+}
+
+func (h *homeAssistant) Fetch(loc weatherLocation) (weatherData, error) {
+	var state haState
+	if err := h.call("GET", "/api/states/"+h.entity, nil, &state); err != nil {
+		return weatherData{}, err
+	}
+	var attrs struct {
+		Temperature     float64      `json:"temperature"`
+		TemperatureUnit string       `json:"temperature_unit"`
+		Forecast        []haForecast `json:"forecast"`
+	}
+	if err := json.Unmarshal(state.Attributes, &attrs); err != nil {
+		return weatherData{}, err
+	}
+
+	result := weatherData{
+		Temp:      attrs.Temperature,
+		Condition: haCondition(state.State),
+		IsDay:     state.State != "clear-night",
+	}
+
+	// The sun integration is optional; without it, trust the condition.
+	var sun haState
+	if err := h.call("GET", "/api/states/sun.sun", nil, &sun); err == nil {
+		var sunAttrs struct {
+			NextRising  string `json:"next_rising"`
+			NextSetting string `json:"next_setting"`
+		}
+		json.Unmarshal(sun.Attributes, &sunAttrs)
+		result.IsDay = sun.State == "above_horizon"
+		result.Sunrise = localClock(sunAttrs.NextRising)
+		result.Sunset = localClock(sunAttrs.NextSetting)
+	}
+
+	// Since 2024.3 the forecast is only available through a service call; older
+	// releases still carry it as an attribute.
+	forecast, err := h.dailyForecast()
+	if err != nil {
+		forecast = attrs.Forecast
+	}
+	sort.Slice(forecast, func(i, j int) bool { return forecast[i].Datetime < forecast[j].Datetime })
+	for _, f := range forecast {
+		date, err := time.Parse(time.RFC3339, f.Datetime)
+		if err != nil {
+			continue
+		}
+		day := weatherDay{
+			Date:      date.Local(),
+			Condition: haCondition(f.Condition),
+			High:      f.Temperature,
+			Low:       f.Temperature,
+		}
+		if f.TempLow != nil {
+			day.Low = *f.TempLow
+		}
+		if f.PrecipitationProbability != nil {
+			day.Precip = int(*f.PrecipitationProbability)
+		}
+		result.Days = append(result.Days, day)
+	}
+
+	fahrenheit := attrs.TemperatureUnit == "°F"
+	if fahrenheit != loc.Fahrenheit {
+		convert := func(t float64) float64 { return (t - 32) * 5 / 9 }
+		if loc.Fahrenheit {
+			convert = func(t float64) float64 { return t*9/5 + 32 }
+		}
+		result.Temp = convert(result.Temp)
+		for i := range result.Days {
+			result.Days[i].High = convert(result.Days[i].High)
+			result.Days[i].Low = convert(result.Days[i].Low)
+		}
+	}
+	return result, nil
+}
+
+func (h *homeAssistant) dailyForecast() ([]haForecast, error) {
+	body, _ := json.Marshal(map[string]string{"entity_id": h.entity, "type": "daily"})
+	var resp struct {
+		ServiceResponse map[string]struct {
+			Forecast []haForecast `json:"forecast"`
+		} `json:"service_response"`
+	}
+	if err := h.call("POST", "/api/services/weather/get_forecasts?return_response", body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.ServiceResponse[h.entity].Forecast, nil
+}
+
+func (h *homeAssistant) call(method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, h.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("home assistant %s status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// localClock formats an RFC 3339 time as "15:04" in the server's time zone.
+func localClock(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ""
+	}
+	return t.Local().Format("15:04")
+}
+
+// haCondition maps a Home Assistant weather condition to a Condition.
+func haCondition(state string) Condition {
+	switch state {
+	case "sunny", "clear-night":
+		return ConditionClear
+	case "partlycloudy":
+		return ConditionPartlyCloudy
+	case "cloudy":
+		return ConditionCloudy
+	case "windy", "windy-variant":
+		return ConditionMostlyCloudy
+	case "fog":
+		return ConditionFog
+	case "hail":
+		return ConditionSleet
+	case "rainy":
+		return ConditionRain
+	case "pouring":
+		return ConditionHeavyRain
+	case "snowy-rainy":
+		return ConditionWintryMix
+	case "snowy":
+		return ConditionSnow
+	case "lightning", "lightning-rainy":
+		return ConditionThunderstorm
+	default:
+		return ConditionUnknown
+	}
+}
@@ -0,0 +1,95 @@
+package immich
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Statistics returns how many assets the key's user has.
+func (c *Client) Statistics(ctx context.Context) (Statistics, error) {
+	var s Statistics
+	err := c.do(ctx, "GET", c.current().assets()+"/statistics", nil, &s)
+	return s, err
+}
+
+// SearchMetadata runs a metadata search and returns one page of it. On a
+// server older than OldestSupported it fails with ErrUnsupported rather than
+// report a page that may be empty only because the server did not understand
+// the query.
+func (c *Client) SearchMetadata(ctx context.Context, q MetadataQuery) (SearchResult, error) {
+	d := c.current()
+	if d.unsupported {
+		return SearchResult{}, fmt.Errorf("search needs Immich %s or newer, server is %s: %w", OldestSupported, c.Version(), ErrUnsupported)
+	}
+	q, err := d.search(q)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	var resp struct {
+		Assets SearchResult `json:"assets"`
+	}
+	err = c.do(ctx, "POST", "/api/search/metadata", q, &resp)
+	return resp.Assets, err
+}
+
+// Asset returns one asset with its EXIF data.
+func (c *Client) Asset(ctx context.Context, id string) (Asset, error) {
+	var a Asset
+	err := c.do(ctx, "GET", c.current().assetPath(id), nil, &a)
+	return a, err
+}
+
+// Thumbnail downloads an asset's thumbnail ("thumbnail" or "preview" size),
+// returning its bytes and content type.
+func (c *Client) Thumbnail(ctx context.Context, id, size string) ([]byte, string, error) {
+	resp, err := c.send(ctx, "GET", c.current().thumbnailPath(id, size), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// SetFavorite marks an asset as a favorite, or not.
+func (c *Client) SetFavorite(ctx context.Context, id string, favorite bool) error {
+	return c.do(ctx, "PUT", c.current().assetPath(id), map[string]bool{"isFavorite": favorite}, nil)
+}
+
+// Albums lists the albums the user owns or has been shared.
+func (c *Client) Albums(ctx context.Context) ([]Album, error) {
+	var albums []Album
+	err := c.do(ctx, "GET", c.current().albums(), nil, &albums)
+	return albums, err
+}
+
+// SearchPeople finds people whose name starts with name.
+func (c *Client) SearchPeople(ctx context.Context, name string) ([]Person, error) {
+	var people []Person
+	err := c.do(ctx, "GET", "/api/search/person?name="+url.QueryEscape(name), nil, &people)
+	return people, err
+}
+
+// Tags lists every tag.
+func (c *Client) Tags(ctx context.Context) ([]Tag, error) {
+	var tags []Tag
+	err := c.do(ctx, "GET", c.current().tags(), nil, &tags)
+	return tags, err
+}
+
+// CreateTag creates a top-level tag.
+func (c *Client) CreateTag(ctx context.Context, name string) (Tag, error) {
+	var t Tag
+	err := c.do(ctx, "POST", c.current().tags(), map[string]string{"name": name}, &t)
+	return t, err
+}
+
+// TagAssets adds a tag to assets.
+func (c *Client) TagAssets(ctx context.Context, tagID string, assetIDs []string) error {
+	return c.do(ctx, "PUT", c.current().tags()+"/"+url.PathEscape(tagID)+"/assets", map[string][]string{"ids": assetIDs}, nil)
+}
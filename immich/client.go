@@ -0,0 +1,213 @@
+// Package immich is a small typed client for the parts of the Immich API the
+// photo frame uses. Every call takes a context, failures come back as *Error
+// values that tell auth problems, missing assets and transient outages apart,
+// and transient failures of calls that are safe to repeat are retried with
+// exponential backoff and jitter.
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to one Immich server with one API key.
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+
+	// Retries is how many times a transient failure is retried; Backoff is
+	// the wait before the first retry, doubling up to MaxBackoff.
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	mu      sync.Mutex
+	version Version
+	dialect dialect
+}
+
+// New returns a client for the server at baseURL. The HTTP client's timeout
+// bounds each attempt, not the call as a whole.
+func New(baseURL, apiKey string, hc *http.Client) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		http:       hc,
+		Retries:    2,
+		Backoff:    500 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+	}
+}
+
+// Error kinds, for use with errors.Is.
+var (
+	// ErrAuth means the API key was rejected or lacks a permission.
+	ErrAuth = errors.New("immich: not authorized")
+	// ErrNotFound means the asset, album or other object does not exist.
+	ErrNotFound = errors.New("immich: not found")
+	// ErrTransient means Immich could not be reached or failed on its side;
+	// the same call may well work later.
+	ErrTransient = errors.New("immich: temporarily unavailable")
+)
+
+// Error is a failed API call.
+type Error struct {
+	Method string
+	Path   string
+	// Status is the HTTP status, or 0 if no response came back.
+	Status int
+	// Body is the start of the response body, which Immich fills with a
+	// JSON explanation.
+	Body string
+	// Err is the network error when there was no response.
+	Err  error
+	kind error
+}
+
+func (e *Error) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%s %s: %v", e.Method, e.Path, e.Err)
+	}
+	if e.Body == "" {
+		return fmt.Sprintf("%s %s: status %d", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("%s %s: status %d: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if e.kind != nil {
+		errs = append(errs, e.kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// kindFor classifies an HTTP status.
+func kindFor(status int) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAuth
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusTooManyRequests || status >= 500:
+		return ErrTransient
+	}
+	return nil
+}
+
+// idempotent reports whether a request can safely be sent again. A 502 may
+// come back after Immich has done the work, so a POST that creates something
+// is sent once; the metadata search only reads, POST or not.
+func idempotent(method, path string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return method == "POST" && path == "/api/search/metadata"
+}
+
+// send makes a request, retrying transient failures of idempotent ones, and
+// returns a response with a 2xx status for the caller to read and close.
+func (c *Client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, method, path, data)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= c.Retries || !errors.Is(err, ErrTransient) || !idempotent(method, path) || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, data []byte) (*http.Response, error) {
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &Error{Method: method, Path: path, Err: err, kind: ErrTransient}
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, &Error{
+		Method: method,
+		Path:   path,
+		Status: resp.StatusCode,
+		Body:   strings.TrimSpace(string(msg)),
+		kind:   kindFor(resp.StatusCode),
+	}
+}
+
+// backoff returns the wait before retry n (from 0): the doubling delay with
+// full jitter, so frames restarted together do not retry in lockstep.
+func (c *Client) backoff(n int) time.Duration {
+	d := c.Backoff << n
+	if d <= 0 || d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// do sends a JSON request and decodes the JSON reply into out, if given.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: decode: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package immich_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"immich-ipad/immich"
+	"immich-ipad/immich/immichtest"
+)
+
+func TestTransientFailuresAreRetried(t *testing.T) {
+	fake := immichtest.NewServer(map[string]int{"iPhone XS": 3})
+	t.Cleanup(fake.Close)
+	c := fake.Client()
+
+	fake.FailNext(2)
+	res, err := c.SearchMetadata(context.Background(), immich.MetadataQuery{Model: "iPhone XS", Page: 1, Size: 1})
+	if err != nil {
+		t.Fatalf("search after two failures: %v", err)
+	}
+	if len(res.Items) != 1 || fake.Calls() != 3 {
+		t.Errorf("got %d items after %d calls, want 1 after 3", len(res.Items), fake.Calls())
+	}
+
+	fake.FailNext(3)
+	_, err = c.SearchMetadata(context.Background(), immich.MetadataQuery{Model: "iPhone XS", Page: 1, Size: 1})
+	var apiErr *immich.Error
+	if !errors.Is(err, immich.ErrTransient) || !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadGateway {
+		t.Errorf("search past the retries = %v, want a transient 502", err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	fake := immichtest.NewServer(nil)
+	t.Cleanup(fake.Close)
+
+	if _, err := fake.Client().Asset(context.Background(), "missing"); !errors.Is(err, immich.ErrNotFound) {
+		t.Errorf("missing asset = %v, want ErrNotFound", err)
+	}
+	bad := immich.New(fake.URL, "wrong-key", http.DefaultClient)
+	if _, err := bad.Statistics(context.Background()); !errors.Is(err, immich.ErrAuth) {
+		t.Errorf("wrong key = %v, want ErrAuth", err)
+	}
+	down := immich.New("http://127.0.0.1:1", immichtest.APIKey, http.DefaultClient)
+	down.Retries = 0
+	if _, err := down.Statistics(context.Background()); !errors.Is(err, immich.ErrTransient) {
+		t.Errorf("unreachable server = %v, want ErrTransient", err)
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := immich.New(srv.URL, "key", srv.Client()).Albums(context.Background())
+	if err == nil || errors.Is(err, immich.ErrTransient) || calls.Load() != 1 {
+		t.Errorf("400 = %v after %d calls, want a plain error after 1", err, calls.Load())
+	}
+}
+
+func TestCreateTagIsNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := immich.New(srv.URL, "key", srv.Client())
+	c.Backoff = time.Millisecond
+	if _, err := c.CreateTag(context.Background(), "frame-hidden"); !errors.Is(err, immich.ErrTransient) || calls.Load() != 1 {
+		t.Errorf("CreateTag = %v after %d calls, want a transient error after 1", err, calls.Load())
+	}
+	if _, err := c.Tags(context.Background()); err == nil || calls.Load() != 4 {
+		t.Errorf("Tags made %d calls, want 3 for a read", calls.Load()-1)
+	}
+}
+
+func TestBackoffStopsWithContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := immich.New(srv.URL, "key", srv.Client())
+	c.Retries, c.Backoff, c.MaxBackoff = 10, time.Hour, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.Statistics(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("backoff ignored the context")
+	}
+}
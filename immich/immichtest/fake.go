@@ -0,0 +1,379 @@
+// Package immichtest provides a fake Immich server for tests.
+package immichtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"immich-ipad/immich"
+)
+
+// APIKey is the only key the fake accepts.
+const APIKey = "test-key"
+
+// Server is a fake Immich library. Rather than hold assets, it knows how many
+// assets each search finds: a search keyed k finds "p1" to "p<n>", where
+// n = pages[k], so with a page size of one, page i holds "p<i>" and pages past
+// n come back empty. Larger pages are filled in order and carry a nextPage
+// until the last. Assets are uploaded long ago, except those added by Upload,
+// which are all a search with updatedAfter finds. Those are listed first,
+// newest first, as Immich lists the latest photos first, so each upload moves
+// every older photo one page on. The key is the model, or the
+// album ID, then "|" and the person IDs joined by "+" for a person filter,
+// "|favorites" or "|rating:N" for those filters, and
+// "|taken:<after>..<before>" as local days for a date window. A search by tag
+// returns the assets given that tag instead.
+type Server struct {
+	URL string
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	pages    map[string]int
+	albums   map[string]string
+	people   map[string]string
+	tags     map[string]string          // ID to name
+	tagged   map[string]map[string]bool // tag ID to asset IDs
+	favorite map[string]bool
+	uploaded map[string]map[int]time.Time // key to asset number to upload time
+	calls    int
+	failNext int
+}
+
+// NewServer starts a fake with the given page counts. Close it when done.
+func NewServer(pages map[string]int) *Server {
+	f := &Server{
+		pages:    make(map[string]int),
+		albums:   make(map[string]string),
+		people:   make(map[string]string),
+		tags:     make(map[string]string),
+		tagged:   make(map[string]map[string]bool),
+		favorite: make(map[string]bool),
+		uploaded: make(map[string]map[int]time.Time),
+	}
+	for k, n := range pages {
+		f.pages[k] = n
+	}
+	f.srv = httptest.NewServer(f.handler())
+	f.URL = f.srv.URL
+	return f
+}
+
+func (f *Server) Close() { f.srv.Close() }
+
+// Client returns a client for the fake that retries without waiting.
+func (f *Server) Client() *immich.Client {
+	c := immich.New(f.URL, APIKey, f.srv.Client())
+	c.Backoff, c.MaxBackoff = time.Millisecond, time.Millisecond
+	return c
+}
+
+// SetPages changes how many pages a search has.
+func (f *Server) SetPages(key string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pages[key] = n
+}
+
+// Upload adds n assets to a search, uploaded at a given time, and returns
+// their IDs.
+func (f *Server) Upload(key string, n int, at time.Time) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.uploaded[key] == nil {
+		f.uploaded[key] = make(map[int]time.Time)
+	}
+	var ids []string
+	for i := 0; i < n; i++ {
+		f.pages[key]++
+		f.uploaded[key][f.pages[key]] = at
+		ids = append(ids, fmt.Sprintf("p%d", f.pages[key]))
+	}
+	return ids
+}
+
+// AddAlbum adds an album, for the albums API.
+func (f *Server) AddAlbum(id, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.albums[id] = name
+}
+
+// AddPerson adds a named person, for the person search.
+func (f *Server) AddPerson(id, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.people[id] = name
+}
+
+// Tag gives assets a tag, creating the tag if need be, and returns its ID.
+func (f *Server) Tag(name string, assetIDs ...string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := f.tagID(name)
+	for _, a := range assetIDs {
+		f.tagged[id][a] = true
+	}
+	return id
+}
+
+// Untag takes a tag off an asset.
+func (f *Server) Untag(name, assetID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tagged[f.tagID(name)], assetID)
+}
+
+// Tagged reports whether an asset has a tag.
+func (f *Server) Tagged(name, assetID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, n := range f.tags {
+		if n == name {
+			return f.tagged[id][assetID]
+		}
+	}
+	return false
+}
+
+// tagID finds or creates a tag. Caller must hold f.mu.
+func (f *Server) tagID(name string) string {
+	for id, n := range f.tags {
+		if n == name {
+			return id
+		}
+	}
+	id := fmt.Sprintf("tag-%d", len(f.tags)+1)
+	f.tags[id] = name
+	f.tagged[id] = make(map[string]bool)
+	return id
+}
+
+// Favorite reports whether an asset was marked a favorite.
+func (f *Server) Favorite(assetID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.favorite[assetID]
+}
+
+// FailNext makes the next n searches fail with a 502, as while Immich
+// restarts.
+func (f *Server) FailNext(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failNext = n
+}
+
+// Calls returns how many searches have been made.
+func (f *Server) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/server/version", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(immich.NewestTested)
+	})
+	mux.HandleFunc("GET /api/assets/statistics", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(immich.Statistics{Images: 100000, Total: 100000})
+	})
+	mux.HandleFunc("GET /api/albums", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		albums := []immich.Album{}
+		for id, name := range f.albums {
+			albums = append(albums, immich.Album{ID: id, AlbumName: name})
+		}
+		json.NewEncoder(w).Encode(albums)
+	})
+	mux.HandleFunc("GET /api/search/person", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		people := []immich.Person{}
+		for id, name := range f.people {
+			if strings.HasPrefix(strings.ToLower(name), strings.ToLower(r.URL.Query().Get("name"))) {
+				people = append(people, immich.Person{ID: id, Name: name})
+			}
+		}
+		json.NewEncoder(w).Encode(people)
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		tags := []immich.Tag{}
+		for id, name := range f.tags {
+			tags = append(tags, immich.Tag{ID: id, Name: name, Value: name})
+		}
+		json.NewEncoder(w).Encode(tags)
+	})
+	mux.HandleFunc("POST /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name string `json:"name"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		id := f.tagID(body.Name)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(immich.Tag{ID: id, Name: body.Name, Value: body.Name})
+	})
+	mux.HandleFunc("PUT /api/tags/{id}/assets", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		tagged, ok := f.tagged[r.PathValue("id")]
+		if !ok {
+			http.Error(w, `{"message":"Tag not found"}`, http.StatusBadRequest)
+			return
+		}
+		for _, id := range body.IDs {
+			tagged[id] = true
+		}
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("POST /api/search/metadata", f.search)
+	mux.HandleFunc("GET /api/assets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !strings.HasPrefix(id, "p") {
+			http.Error(w, `{"message":"Asset not found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(immich.Asset{
+			ID:               id,
+			FileCreatedAt:    "2024-01-01T00:00:00.000Z",
+			OriginalFileName: "IMG_" + id + ".HEIC",
+			ExifInfo:         &immich.Exif{City: "Istanbul", Country: "Turkey"},
+		})
+	})
+	mux.HandleFunc("PUT /api/assets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			IsFavorite *bool `json:"isFavorite"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if body.IsFavorite != nil {
+			f.favorite[r.PathValue("id")] = *body.IsFavorite
+		}
+		json.NewEncoder(w).Encode(immich.Asset{ID: r.PathValue("id")})
+	})
+	mux.HandleFunc("GET /api/assets/{id}/thumbnail", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 3)), nil)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(buf.Bytes())
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != APIKey {
+			http.Error(w, `{"message":"Invalid API key"}`, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (f *Server) search(w http.ResponseWriter, r *http.Request) {
+	var q immich.MetadataQuery
+	json.NewDecoder(r.Body).Decode(&q)
+
+	f.mu.Lock()
+	f.calls++
+	if f.failNext > 0 {
+		f.failNext--
+		f.mu.Unlock()
+		http.Error(w, "immich is restarting", http.StatusBadGateway)
+		return
+	}
+
+	var items []string
+	if len(q.TagIDs) > 0 {
+		for id := range f.tagged[q.TagIDs[0]] {
+			items = append(items, fmt.Sprintf(`{"id":%q}`, id))
+		}
+		f.mu.Unlock()
+		fmt.Fprintf(w, `{"assets":{"items":[%s],"nextPage":null}}`, strings.Join(items, ","))
+		return
+	}
+	key := Key(q)
+	count := f.pages[key]
+	uploaded := f.uploaded[key]
+	// A search limited to recent changes finds only the uploads since.
+	var found []int
+	if q.UpdatedAfter != nil {
+		for i, at := range uploaded {
+			if at.After(*q.UpdatedAfter) {
+				found = append(found, i)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(found)))
+		count = len(found)
+	}
+
+	size := max(q.Size, 1)
+	first := (q.Page-1)*size + 1
+	last := min(q.Page*size, count)
+	for i := max(first, 1); i <= last; i++ {
+		n := i - len(uploaded)
+		switch {
+		case found != nil:
+			n = found[i-1]
+		case i <= len(uploaded):
+			n = count - i + 1
+		}
+		createdAt := "2024-01-01T00:00:00.000Z"
+		if at, ok := uploaded[n]; ok {
+			createdAt = at.UTC().Format(time.RFC3339Nano)
+		}
+		items = append(items, fmt.Sprintf(`{"id":"p%d","fileCreatedAt":"2024-01-01T00:00:00.000Z","createdAt":%q,"originalFileName":"IMG_%d.HEIC"}`, n, createdAt, n))
+	}
+	f.mu.Unlock()
+	next := "null"
+	if q.Page >= 1 && last < count {
+		next = fmt.Sprintf(`"%d"`, q.Page+1)
+	}
+	fmt.Fprintf(w, `{"assets":{"items":[%s],"nextPage":%s}}`, strings.Join(items, ","), next)
+}
+
+// Key returns the page count key a search is looked up by.
+func Key(q immich.MetadataQuery) string {
+	key := q.Model
+	if len(q.AlbumIDs) > 0 {
+		key = q.AlbumIDs[0]
+	}
+	if len(q.PersonIDs) > 0 {
+		key += "|" + strings.Join(q.PersonIDs, "+")
+	}
+	if q.IsFavorite != nil && *q.IsFavorite {
+		key += "|favorites"
+	}
+	if q.Rating > 0 {
+		key += fmt.Sprintf("|rating:%d", q.Rating)
+	}
+	if q.TakenAfter != nil || q.TakenBefore != nil {
+		key += "|taken:" + localDay(q.TakenAfter) + ".." + localDay(q.TakenBefore)
+	}
+	return key
+}
+
+// localDay turns a search bound back into the local day it was made from, or
+// "" for an open end.
+func localDay(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.In(time.Local).Format("2006-01-02")
+}
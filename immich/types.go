@@ -0,0 +1,112 @@
+package immich
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Statistics counts a user's assets.
+type Statistics struct {
+	Images int `json:"images"`
+	Videos int `json:"videos"`
+	Total  int `json:"total"`
+}
+
+// Asset is an asset as returned by search and by the asset endpoint.
+type Asset struct {
+	ID               string `json:"id"`
+	FileCreatedAt    string `json:"fileCreatedAt"`
+	CreatedAt        string `json:"createdAt"` // when it was uploaded
+	OriginalFileName string `json:"originalFileName"`
+	OriginalMimeType string `json:"originalMimeType"`
+	DuplicateID      string `json:"duplicateId"`
+	IsFavorite       bool   `json:"isFavorite"`
+	// ExifInfo is always sent by the asset endpoint, but by search only
+	// when the query sets WithExif.
+	ExifInfo *Exif `json:"exifInfo"`
+}
+
+// Exif is the part of an asset's EXIF data the frame looks at.
+type Exif struct {
+	ExifImageWidth  int      `json:"exifImageWidth"`
+	ExifImageHeight int      `json:"exifImageHeight"`
+	Make            string   `json:"make"`
+	Model           string   `json:"model"`
+	City            string   `json:"city"`
+	State           string   `json:"state"`
+	Country         string   `json:"country"`
+	Latitude        *float64 `json:"latitude"`
+	Longitude       *float64 `json:"longitude"`
+}
+
+// MetadataQuery is a metadata search. Zero fields are left out of the request.
+type MetadataQuery struct {
+	Type         string     `json:"type,omitempty"`
+	Page         int        `json:"page,omitempty"`
+	Size         int        `json:"size,omitempty"`
+	Visibility   string     `json:"visibility,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	AlbumIDs     []string   `json:"albumIds,omitempty"`
+	PersonIDs    []string   `json:"personIds,omitempty"`
+	TagIDs       []string   `json:"tagIds,omitempty"`
+	IsFavorite   *bool      `json:"isFavorite,omitempty"`
+	Rating       int        `json:"rating,omitempty"`
+	TakenAfter   *time.Time `json:"takenAfter,omitempty"`
+	TakenBefore  *time.Time `json:"takenBefore,omitempty"`
+	UpdatedAfter *time.Time `json:"updatedAfter,omitempty"` // added or changed since
+	WithExif     bool       `json:"withExif,omitempty"`
+}
+
+// SearchResult is one page of search results. NextPage is empty on the last
+// page.
+type SearchResult struct {
+	Items    []Asset `json:"items"`
+	NextPage string  `json:"nextPage"`
+}
+
+// UnmarshalJSON accepts nextPage as a string, a number or null, so a change
+// in how a release types it cannot end paging early or fail the search.
+func (r *SearchResult) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Items    []Asset         `json:"items"`
+		NextPage json.RawMessage `json:"nextPage"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	r.Items = raw.Items
+	r.NextPage = ""
+	var next interface{}
+	if len(raw.NextPage) > 0 {
+		if err := json.Unmarshal(raw.NextPage, &next); err != nil {
+			return err
+		}
+	}
+	switch v := next.(type) {
+	case string:
+		r.NextPage = v
+	case float64:
+		r.NextPage = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return nil
+}
+
+// Album is an album, without its assets.
+type Album struct {
+	ID        string `json:"id"`
+	AlbumName string `json:"albumName"`
+}
+
+// Person is a recognised face with a name.
+type Person struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Tag is a tag. Value is its full path, which for a top-level tag is its name.
+type Tag struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
@@ -0,0 +1,160 @@
+package immich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Version is an Immich server release.
+type Version struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Before reports whether v is an older release than o.
+func (v Version) Before(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+var (
+	// OldestSupported is the first release with the metadata search the
+	// frame is built on.
+	OldestSupported = Version{1, 94, 0}
+	// NewestTested is the newest release the client is known to work with.
+	// Later ones are talked to as if they were this one.
+	NewestTested = Version{2, 1, 0}
+)
+
+// ErrUnsupported means the server is older than OldestSupported, so the call
+// was not made: its answer could not be trusted.
+var ErrUnsupported = errors.New("immich: server version not supported")
+
+// dialect is how a range of Immich releases spells the calls the client
+// makes. The zero dialect is the current API.
+type dialect struct {
+	// singular is the API before v1.106, which used /api/asset, /api/album,
+	// /api/tag and /api/server-info where later releases use the plurals and
+	// /api/server.
+	singular bool
+	// noVisibility is the API before v1.133, which had no visibility filter
+	// and left archived assets out of searches by default.
+	noVisibility bool
+	// noRating and noTags are the API before v1.113 and v1.114, whose search
+	// had no rating or tag filter. withExif and updatedAfter are as old as
+	// the metadata search itself.
+	noRating bool
+	noTags   bool
+	// unsupported is set for servers older than OldestSupported.
+	unsupported bool
+}
+
+// dialectFor picks the dialect for a server version.
+func dialectFor(v Version) dialect {
+	return dialect{
+		singular:     v.Before(Version{1, 106, 0}),
+		noVisibility: v.Before(Version{1, 133, 0}),
+		noRating:     v.Before(Version{1, 113, 0}),
+		noTags:       v.Before(Version{1, 114, 0}),
+		unsupported:  v.Before(OldestSupported),
+	}
+}
+
+func (d dialect) assets() string {
+	if d.singular {
+		return "/api/asset"
+	}
+	return "/api/assets"
+}
+
+func (d dialect) albums() string {
+	if d.singular {
+		return "/api/album"
+	}
+	return "/api/albums"
+}
+
+func (d dialect) tags() string {
+	if d.singular {
+		return "/api/tag"
+	}
+	return "/api/tags"
+}
+
+func (d dialect) assetPath(id string) string {
+	return d.assets() + "/" + url.PathEscape(id)
+}
+
+// thumbnailPath returns where an asset's thumbnail of a size is. Releases
+// before v1.106 chose by format instead, and their WEBP one is small and
+// cannot be decoded here, so both sizes ask for the JPEG, which is the
+// preview.
+func (d dialect) thumbnailPath(id, size string) string {
+	if d.singular {
+		return "/api/asset/thumbnail/" + url.PathEscape(id) + "?format=JPEG"
+	}
+	return d.assetPath(id) + "/thumbnail?size=" + url.QueryEscape(size)
+}
+
+// search rewrites a query for the server. Older servers reject properties
+// they do not know, so ones they predate are left out. A filter cannot be
+// left out without widening the results, so a query that needs one the
+// server lacks fails with ErrUnsupported instead.
+func (d dialect) search(q MetadataQuery) (MetadataQuery, error) {
+	switch {
+	case d.noRating && q.Rating > 0:
+		return q, fmt.Errorf("rating filter needs Immich v1.113.0 or newer: %w", ErrUnsupported)
+	case d.noTags && len(q.TagIDs) > 0:
+		return q, fmt.Errorf("tag filter needs Immich v1.114.0 or newer: %w", ErrUnsupported)
+	}
+	if d.noVisibility {
+		q.Visibility = ""
+	}
+	return q, nil
+}
+
+// DetectVersion asks the server for its version and from then on talks to it
+// in the matching dialect. Until it succeeds the client assumes the current
+// API.
+func (c *Client) DetectVersion(ctx context.Context) (Version, error) {
+	var v Version
+	err := c.do(ctx, "GET", "/api/server/version", nil, &v)
+	if errors.Is(err, ErrNotFound) {
+		// Releases before v1.106 only answer on the old path.
+		err = c.do(ctx, "GET", "/api/server-info/version", nil, &v)
+	}
+	if err != nil {
+		return Version{}, err
+	}
+	c.mu.Lock()
+	c.version = v
+	c.dialect = dialectFor(v)
+	c.mu.Unlock()
+	return v, nil
+}
+
+// Version returns the server version found by DetectVersion, or the zero
+// Version if it has not succeeded yet.
+func (c *Client) Version() Version {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+func (c *Client) current() dialect {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dialect
+}
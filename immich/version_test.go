@@ -0,0 +1,170 @@
+package immich_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"immich-ipad/immich"
+)
+
+// versionServer is an Immich of a given release that records each request as
+// "METHOD path body". It answers the version on the path that release used.
+func versionServer(t *testing.T, v immich.Version) (*immich.Client, func() []string) {
+	var mu sync.Mutex
+	var requests []string
+	versionPath := "/api/server/version"
+	if v.Before(immich.Version{Major: 1, Minor: 106}) {
+		versionPath = "/api/server-info/version"
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+		mu.Unlock()
+		switch r.URL.Path {
+		case versionPath:
+			json.NewEncoder(w).Encode(v)
+		case "/api/server/version", "/api/server-info/version":
+			http.NotFound(w, r)
+		case "/api/search/metadata":
+			w.Write([]byte(`{"assets":{"items":[{"id":"a"}],"nextPage":2}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	c := immich.New(srv.URL, "key", srv.Client())
+	return c, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func TestDialectFollowsServerVersion(t *testing.T) {
+	tests := []struct {
+		version    immich.Version
+		statistics string
+		thumbnail  string
+		visibility bool
+		albums     string
+		tags       string
+	}{
+		{immich.Version{Major: 1, Minor: 100}, "/api/asset/statistics", "/api/asset/thumbnail/a?format=JPEG", false, "/api/album", "/api/tag"},
+		{immich.Version{Major: 1, Minor: 120}, "/api/assets/statistics", "/api/assets/a/thumbnail?size=preview", false, "/api/albums", "/api/tags"},
+		{immich.Version{Major: 2, Minor: 0}, "/api/assets/statistics", "/api/assets/a/thumbnail?size=preview", true, "/api/albums", "/api/tags"},
+	}
+	for _, tt := range tests {
+		c, requests := versionServer(t, tt.version)
+		ctx := context.Background()
+		if v, err := c.DetectVersion(ctx); err != nil || v != tt.version {
+			t.Fatalf("%s: DetectVersion = %s, %v", tt.version, v, err)
+		}
+		c.Statistics(ctx)
+		c.Thumbnail(ctx, "a", "preview")
+		res, err := c.SearchMetadata(ctx, immich.MetadataQuery{Page: 1, Visibility: "timeline"})
+		if err != nil {
+			t.Fatalf("%s: search: %v", tt.version, err)
+		}
+		if res.NextPage != "2" {
+			t.Errorf("%s: numeric nextPage decoded as %q, want \"2\"", tt.version, res.NextPage)
+		}
+
+		got := requests()
+		got = got[len(got)-3:]
+		if want := "GET " + tt.statistics; got[0] != want {
+			t.Errorf("%s: statistics = %q, want %q", tt.version, got[0], want)
+		}
+		if want := "GET " + tt.thumbnail; got[1] != want {
+			t.Errorf("%s: thumbnail = %q, want %q", tt.version, got[1], want)
+		}
+		if sent := strings.Contains(got[2], `"visibility"`); sent != tt.visibility {
+			t.Errorf("%s: search %q, want visibility sent = %v", tt.version, got[2], tt.visibility)
+		}
+
+		c.Albums(ctx)
+		c.Tags(ctx)
+		c.CreateTag(ctx, "frame-hidden")
+		c.TagAssets(ctx, "t", []string{"a"})
+		got = requests()
+		got = got[len(got)-4:]
+		want := []string{
+			"GET " + tt.albums,
+			"GET " + tt.tags,
+			`POST ` + tt.tags + ` {"name":"frame-hidden"}`,
+			`PUT ` + tt.tags + `/t/assets {"ids":["a"]}`,
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: request %d = %q, want %q", tt.version, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestSearchLeavesOutWhatTheServerPredates(t *testing.T) {
+	tests := []struct {
+		version        immich.Version
+		rating, tagged bool
+	}{
+		{immich.Version{Major: 1, Minor: 100}, false, false},
+		{immich.Version{Major: 1, Minor: 113}, true, false},
+		{immich.Version{Major: 1, Minor: 114}, true, true},
+	}
+	for _, tt := range tests {
+		c, requests := versionServer(t, tt.version)
+		ctx := context.Background()
+		if _, err := c.DetectVersion(ctx); err != nil {
+			t.Fatalf("%s: DetectVersion: %v", tt.version, err)
+		}
+		since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		if _, err := c.SearchMetadata(ctx, immich.MetadataQuery{Page: 1, WithExif: true, UpdatedAfter: &since}); err != nil {
+			t.Fatalf("%s: search: %v", tt.version, err)
+		}
+		got := requests()
+		if last := got[len(got)-1]; !strings.Contains(last, `"withExif":true`) || !strings.Contains(last, `"updatedAfter"`) {
+			t.Errorf("%s: search %q, want withExif and updatedAfter sent", tt.version, last)
+		}
+
+		// A filter the server lacks would widen the results if dropped.
+		for _, q := range []struct {
+			name string
+			ok   bool
+			q    immich.MetadataQuery
+		}{
+			{"rating", tt.rating, immich.MetadataQuery{Page: 1, Rating: 4}},
+			{"tag", tt.tagged, immich.MetadataQuery{Page: 1, TagIDs: []string{"t"}}},
+		} {
+			before := len(requests())
+			_, err := c.SearchMetadata(ctx, q.q)
+			if q.ok && err != nil {
+				t.Errorf("%s: %s search = %v", tt.version, q.name, err)
+			}
+			if !q.ok && (!errors.Is(err, immich.ErrUnsupported) || len(requests()) != before) {
+				t.Errorf("%s: %s search = %v after %d requests, want ErrUnsupported without asking", tt.version, q.name, err, len(requests())-before)
+			}
+		}
+	}
+}
+
+func TestTooOldServerRefusesSearch(t *testing.T) {
+	c, requests := versionServer(t, immich.Version{Major: 1, Minor: 90})
+	if _, err := c.DetectVersion(context.Background()); err != nil {
+		t.Fatalf("DetectVersion: %v", err)
+	}
+	before := len(requests())
+	if _, err := c.SearchMetadata(context.Background(), immich.MetadataQuery{Page: 1}); !errors.Is(err, immich.ErrUnsupported) {
+		t.Errorf("search on v1.90 = %v, want ErrUnsupported", err)
+	}
+	if len(requests()) != before {
+		t.Error("search was sent to a server too old to answer it")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"immich-ipad/immich"
+)
+
+const (
+	// indexPageSize is how many assets each request of an index walk asks
+	// for, the most Immich returns in one page.
+	indexPageSize = 1000
+	// indexMaxAge is how long a source's index is used before the source is
+	// walked again.
+	indexMaxAge = 24 * time.Hour
+)
+
+// indexEntry is one photo in a source's index: what a PhotoInfo is made from.
+// The JSON names are short because a large library has a lot of them.
+type indexEntry struct {
+	ID          string `json:"i"`
+	CreatedAt   string `json:"t"`
+	Device      string `json:"d,omitempty"`
+	DuplicateID string `json:"u,omitempty"`
+}
+
+// indexedSource is the INDEX_MODE index of one source: every photo in it that
+// passed the exclusion rules when it was walked.
+type indexedSource struct {
+	BuiltAt time.Time    `json:"builtAt"`
+	Entries []indexEntry `json:"entries"`
+}
+
+func entryFor(a immich.Asset) indexEntry {
+	e := indexEntry{ID: a.ID, CreatedAt: a.FileCreatedAt, DuplicateID: a.DuplicateID}
+	if a.ExifInfo != nil {
+		e.Device = strings.TrimSpace(a.ExifInfo.Make + " " + a.ExifInfo.Model)
+	}
+	return e
+}
+
+func (e indexEntry) photo(loc *locale) PhotoInfo {
+	p := PhotoInfo{
+		ID:          e.ID,
+		Date:        formatDate(e.CreatedAt, loc),
+		device:      e.Device,
+		duplicateID: e.DuplicateID,
+	}
+	p.takenAt, _ = time.Parse(time.RFC3339Nano, e.CreatedAt)
+	return p
+}
+
+// walkSource lists every photo in a source. Excluded photos are left out;
+// hidden ones are kept, since they can be unhidden, and skipped when picking
+// instead.
+func (c *PhotoCache) walkSource(src Source) ([]indexEntry, int, error) {
+	// Device names repeat across a library; share one copy of each.
+	devices := make(map[string]string)
+	var entries []indexEntry
+	requests, err := c.walk(src, time.Time{}, func(a immich.Asset) {
+		e := entryFor(a)
+		if d, ok := devices[e.Device]; ok {
+			e.Device = d
+		} else {
+			devices[e.Device] = e.Device
+		}
+		entries = append(entries, e)
+	})
+	if err != nil {
+		return nil, requests, err
+	}
+	return entries, requests, nil
+}
+
+// walk passes each photo in a source that the exclusion rules let through to
+// fn, a thousand at a time, following nextPage to the end. A non-zero since
+// limits it to photos added or changed after then. It returns how many
+// requests it took.
+func (c *PhotoCache) walk(src Source, since time.Time, fn func(immich.Asset)) (int, error) {
+	q, rules := c.search(src, 1, indexPageSize)
+	if !since.IsZero() {
+		t := since.UTC()
+		q.UpdatedAfter = &t
+	}
+	requests := 0
+	for {
+		result, err := c.api.SearchMetadata(context.Background(), q)
+		if err != nil {
+			return requests, err
+		}
+		requests++
+		for _, a := range result.Items {
+			if _, ok := rules.excludes(a); !ok {
+				fn(a)
+			}
+		}
+		if result.NextPage == "" || len(result.Items) == 0 {
+			return requests, nil
+		}
+		next, err := strconv.Atoi(result.NextPage)
+		if err != nil || next <= q.Page {
+			return requests, fmt.Errorf("unexpected nextPage %q after page %d", result.NextPage, q.Page)
+		}
+		q.Page = next
+	}
+}
+
+// refreshIndex returns how many photos a source holds, walking it again if its
+// index is missing or older than indexMaxAge. It reports whether it walked, so
+// the caller knows to save the index.
+func (c *PhotoCache) refreshIndex(src Source) (int, bool, error) {
+	key := src.key()
+	c.mu.Lock()
+	idx, ok := c.index[key]
+	c.mu.Unlock()
+	if ok && time.Since(idx.BuiltAt) < indexMaxAge {
+		return len(idx.Entries), false, nil
+	}
+
+	start := time.Now()
+	entries, requests, err := c.walkSource(src)
+	if err != nil {
+		return 0, false, err
+	}
+	log.Printf("Indexed %d photos of %q in %d requests (%s)", len(entries), src.Name, requests, time.Since(start).Round(time.Millisecond))
+
+	c.mu.Lock()
+	c.index[key] = indexedSource{BuiltAt: start, Entries: entries}
+	c.checked[key] = start
+	c.mu.Unlock()
+	return len(entries), true, nil
+}
+
+// pruneIndex forgets the indexes of sources no longer configured.
+func (c *PhotoCache) pruneIndex(sources []Source) {
+	keep := make(map[string]bool, len(sources))
+	for _, src := range sources {
+		keep[src.key()] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.index {
+		if !keep[key] {
+			delete(c.index, key)
+		}
+	}
+}
+
+// indexPath returns where the index is kept, or "" without STATE_DIR. It is a
+// file of its own, apart from the state saved every minute, because it only
+// changes when a source is walked.
+func (c *PhotoCache) indexPath() string {
+	if c.cfg.StateDir == "" || !c.cfg.IndexMode {
+		return ""
+	}
+	return filepath.Join(c.cfg.StateDir, "index.json")
+}
+
+// loadIndex restores the index written by saveIndex, so a restart does not
+// walk the whole library again.
+func (c *PhotoCache) loadIndex() error {
+	path := c.indexPath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var index map[string]indexedSource
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	photos := 0
+	for key, idx := range index {
+		c.index[key] = idx
+		photos += len(idx.Entries)
+	}
+	log.Printf("Restored index from %s: %d sources, %d photos", path, len(index), photos)
+	return nil
+}
+
+// saveIndex writes the index with writeFileAtomic, like saveState.
+func (c *PhotoCache) saveIndex() error {
+	path := c.indexPath()
+	if path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	data, err := json.Marshal(c.index)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
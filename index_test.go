@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIndexModeShowsEveryPhotoOncePerCycle(t *testing.T) {
+	c, fake := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 2500})
+	c.cfg.IndexMode = true
+	c.cfg.StateDir = t.TempDir()
+
+	if ok := c.refreshTotal(); !ok {
+		t.Fatal("refreshTotal reported no usable counts")
+	}
+	if got := c.maxPages["iPhone XS"]; got != 2500 {
+		t.Errorf("count = %d, want exactly 2500", got)
+	}
+	if calls := fake.Calls(); calls != 3 {
+		t.Errorf("indexing took %d searches, want 3 pages of 1000", calls)
+	}
+	c.refreshTotal()
+	if calls := fake.Calls(); calls != 3 {
+		t.Errorf("a refresh within a day walked the library again (%d searches)", calls)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 2500; i++ {
+		if !c.fillQueue() {
+			t.Fatalf("no photo picked after %d of 2500", i)
+		}
+		p := c.next()
+		if seen[p.ID] {
+			t.Fatalf("%s repeated after %d photos", p.ID, i)
+		}
+		seen[p.ID] = true
+	}
+	if calls := fake.Calls(); calls != 3 {
+		t.Errorf("picking made %d searches, want none", calls-3)
+	}
+
+	restarted := newPhotoCache(c.cfg, c.api)
+	if err := restarted.loadIndex(); err != nil {
+		t.Fatalf("loadIndex: %v", err)
+	}
+	restarted.refreshTotal()
+	if got := restarted.maxPages["iPhone XS"]; got != 2500 || fake.Calls() != 3 {
+		t.Errorf("after restart: count %d after %d searches, want 2500 from the saved index", got, fake.Calls()-3)
+	}
+}
+
+// The daily rebuild lays the index out afresh, so offsets played earlier in
+// the cycle point at other photos afterwards; the cycle must still show each
+// photo once.
+func TestIndexRebuildMidCycleStillShowsEveryPhotoOnce(t *testing.T) {
+	c, fake := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 30})
+	c.cfg.IndexMode = true
+	c.cfg.ShuffleSeed = 5
+	c.seed = newSeed(c.cfg)
+	c.refreshTotal()
+
+	seen := make(map[string]bool)
+	show := func() {
+		for tries := 0; !c.fillQueue(); tries++ {
+			if tries == 5 {
+				t.Fatalf("nothing picked after %d photos", len(seen))
+			}
+		}
+		id := c.next().ID
+		if seen[id] {
+			t.Fatalf("%s shown twice in cycle %d", id, c.cycle)
+		}
+		seen[id] = true
+	}
+	for i := 0; i < 15; i++ {
+		show()
+	}
+
+	// Two uploads come first in Immich's order, so the rebuilt index has
+	// every photo two places on.
+	fake.Upload("iPhone XS", 2, time.Now())
+	c.mu.Lock()
+	idx := c.index["iPhone XS"]
+	idx.BuiltAt = time.Now().Add(-indexMaxAge - time.Minute)
+	c.index["iPhone XS"] = idx
+	c.mu.Unlock()
+	calls := fake.Calls()
+	c.refreshTotal()
+	if fake.Calls() == calls {
+		t.Fatal("an index past indexMaxAge was not rebuilt")
+	}
+
+	for i := 15; i < 32; i++ {
+		show()
+	}
+	if len(seen) != 32 || c.cycle != 0 {
+		t.Errorf("%d photos shown by cycle %d, want all 32 in the first", len(seen), c.cycle)
+	}
+}
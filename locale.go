@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// locale holds the strings and layouts the frame shows, selected by LOCALE.
+type locale struct {
+	Months []string
+	// Weekdays are short day names for the forecast strip, indexed by
+	// time.Weekday.
+	Weekdays []string
+	// DateFormat lays out a date from its day (%[1]d), month name (%[2]s) and
+	// year (%[3]d), which covers both day-month-year and month-day-year.
+	DateFormat string
+	// YearAgo and YearsAgo label "on this day" photos; YearsAgo takes the
+	// number of years.
+	YearAgo  string
+	YearsAgo string
+	// Connecting is shown until the first photo arrives.
+	Connecting string
+	// PercentFirst writes percentages as "%30" rather than "30%".
+	PercentFirst bool
+}
+
+var locales = map[string]*locale{
+	"tr": {
+		Months: []string{
+			"Ocak", "Şubat", "Mart", "Nisan", "Mayıs", "Haziran",
+			"Temmuz", "Ağustos", "Eylül", "Ekim", "Kasım", "Aralık",
+		},
+		Weekdays:     []string{"Paz", "Pzt", "Sal", "Çar", "Per", "Cum", "Cmt"},
+		DateFormat:   "%[1]d %[2]s %[3]d",
+		YearAgo:      "1 yıl önce",
+		YearsAgo:     "%d yıl önce",
+		Connecting:   "Sunucuya baglaniyor...",
+		PercentFirst: true,
+	},
+	"en": {
+		Months: []string{
+			"January", "February", "March", "April", "May", "June",
+			"July", "August", "September", "October", "November", "December",
+		},
+		Weekdays:   []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		DateFormat: "%[2]s %[1]d, %[3]d",
+		YearAgo:    "1 year ago",
+		YearsAgo:   "%d years ago",
+		Connecting: "Connecting to server...",
+	},
+	"de": {
+		Months: []string{
+			"Januar", "Februar", "März", "April", "Mai", "Juni",
+			"Juli", "August", "September", "Oktober", "November", "Dezember",
+		},
+		Weekdays:   []string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+		DateFormat: "%[1]d. %[2]s %[3]d",
+		YearAgo:    "vor einem Jahr",
+		YearsAgo:   "vor %d Jahren",
+		Connecting: "Verbinde mit Server...",
+	},
+}
+
+// localeFor returns the locale for a LOCALE value such as "de" or "en-US".
+// Only the language is used, so "en-GB" gets the same dates as "en-US". An
+// unknown or empty value falls back to Turkish, the frame's original language;
+// checkLocale warns about the unknown ones at startup.
+func localeFor(name string) *locale {
+	if l, ok := findLocale(name); ok {
+		return l
+	}
+	return locales["tr"]
+}
+
+func findLocale(name string) (*locale, bool) {
+	name = strings.ToLower(name)
+	if i := strings.IndexAny(name, "-_"); i >= 0 {
+		name = name[:i]
+	}
+	l, ok := locales[name]
+	return l, ok
+}
+
+// checkLocale logs a LOCALE the frame has no translation for, where it is
+// set, rather than switch to Turkish without a word.
+func checkLocale(where, name string) {
+	if _, ok := findLocale(name); name != "" && !ok {
+		log.Printf("%s: LOCALE %q is not supported (use tr, en or de), showing Turkish", where, name)
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
+
+	"immich-ipad/immich"
 )
 
 //go:embed templates/index.html
@@ -31,20 +38,53 @@ func main() {
 	}
 
 	client := &http.Client{Timeout: 120 * time.Second}
+	api := immich.New(cfg.ImmichURL, cfg.ImmichAPIKey, client)
+	detectImmichVersion(api)
+
+	frames, err := loadFrames(cfg, api)
+	if err != nil {
+		log.Fatalf("Failed to load frame profiles: %v", err)
+	}
+
+	// Weather is fetched with its own short timeout: the overlay would rather
+	// show the last reading than wait two minutes on a slow API.
+	weatherProvider, err := newWeatherProvider(cfg, &http.Client{Timeout: 15 * time.Second})
+	if err != nil {
+		log.Fatalf("Failed to set up weather: %v", err)
+	}
 
 	s := &Server{
-		cfg:    cfg,
-		client: client,
-		cache: &PhotoCache{
-			shown:    make(map[string]bool),
-			maxPages: make(map[string]int),
-			client:   client,
-			cfg:      cfg,
-		},
-		tmpl: tmpl,
-	}
-
-	s.cache.startRefreshLoop()
+		cfg:     cfg,
+		api:     api,
+		frames:  frames,
+		photos:  newPhotoLRU(cfg.PhotoCacheMB << 20),
+		tiles:   newTileCache(cfg, client),
+		weather: newWeatherService(weatherProvider),
+		hidden:  newHiddenList(cfg, api),
+		tmpl:    tmpl,
+	}
+
+	if err := s.hidden.load(); err != nil {
+		log.Printf("Hidden list restore error, starting empty: %v", err)
+	}
+	s.hidden.startSyncLoop()
+	for _, f := range s.frames {
+		if err := f.cache.loadState(); err != nil {
+			log.Printf("State restore error for frame %q, starting fresh: %v", f.Name, err)
+		}
+		if err := f.cache.loadIndex(); err != nil {
+			log.Printf("Index restore error for frame %q, rebuilding: %v", f.Name, err)
+		}
+		f.cache.enrich = s.enricher(f)
+		f.cache.hidden = s.hidden
+		f.cache.startRefreshLoop()
+		f.cache.startSaveLoop()
+		f.cache.startPrefetcher()
+		if f.cfg.ShowWeather {
+			s.weather.station(weatherLocationFor(f.cfg))
+		}
+	}
+	s.weather.startRefreshLoop()
 	loadPinImage()
 	s.routes()
 
@@ -52,6 +92,72 @@ func main() {
 	log.Printf("Immich iPad Photo Frame server starting on %s", addr)
 	log.Printf("Immich URL: %s", cfg.ImmichURL)
 	log.Printf("Device models: %s", strings.Join(cfg.DeviceModels, ", "))
+	if len(cfg.Albums) > 0 {
+		log.Printf("Albums: %s", strings.Join(cfg.Albums, ", "))
+	}
+	if len(cfg.People) > 0 {
+		log.Printf("People (%s of): %s", cfg.PeopleMode, strings.Join(cfg.People, ", "))
+	}
 	log.Printf("Slideshow interval: %ds", cfg.SlideshowInterval)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	for name := range s.frames {
+		if name != "" {
+			log.Printf("Frame profile: %s", name)
+		}
+	}
+
+	srv := &http.Server{Addr: addr}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Printf("Shutting down")
+		for _, f := range s.frames {
+			if err := f.cache.saveState(); err != nil {
+				log.Printf("State save error for frame %q: %v", f.Name, err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// detectImmichVersion finds out which Immich release the server runs, so the
+// client speaks its dialect, and warns if it is outside the supported range.
+// If Immich is not up yet it keeps asking in the background; until it answers
+// the current API is assumed.
+func detectImmichVersion(api *immich.Client) {
+	if checkImmichVersion(api) {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(1 * time.Minute)
+			if checkImmichVersion(api) {
+				return
+			}
+		}
+	}()
+}
+
+func checkImmichVersion(api *immich.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	v, err := api.DetectVersion(ctx)
+	if err != nil {
+		log.Printf("Immich version check failed, assuming a current release: %v", err)
+		return false
+	}
+	switch {
+	case v.Before(immich.OldestSupported):
+		log.Printf("WARNING: Immich %s is older than %s, the oldest release this frame supports; no photos will be shown until Immich is upgraded", v, immich.OldestSupported)
+	case immich.NewestTested.Before(immich.Version{Major: v.Major, Minor: v.Minor}):
+		log.Printf("WARNING: Immich %s is newer than %s, the newest release this frame is tested with; if photos stop appearing, update the frame", v, immich.NewestTested)
+	default:
+		log.Printf("Immich version: %s", v)
+	}
+	return true
 }
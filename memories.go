@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// memoryPageSize is how many photos one day of one year may contribute. A
+// single search page is plenty: few people take more than this in a day.
+const memoryPageSize = 1000
+
+// refreshMemories collects the photos taken on today's month and day in each of
+// the previous MEMORIES_YEARS years, from every source. It only hits Immich
+// once a day; later calls on the same day are no-ops.
+func (c *PhotoCache) refreshMemories(now time.Time) {
+	if c.cfg.MemoriesPercent == 0 {
+		return
+	}
+	day := now.Format("2006-01-02")
+
+	c.mu.Lock()
+	if c.memoryDay == day {
+		c.mu.Unlock()
+		return
+	}
+	sources := c.sources
+	c.mu.Unlock()
+
+	if len(sources) == 0 {
+		return
+	}
+
+	var photos []PhotoInfo
+	// A photo can turn up in several sources, once per pool it is in.
+	seen := make(map[string]bool)
+	for yearsAgo := 1; yearsAgo <= c.cfg.MemoriesYears; yearsAgo++ {
+		start := time.Date(now.Year()-yearsAgo, now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		if start.Day() != now.Day() {
+			// 29 February in a year that does not have one.
+			continue
+		}
+		window := dateRange{After: start, Before: start.AddDate(0, 0, 1)}
+		for _, src := range sources {
+			// Stay inside the source's own dates, so DATE_RANGE and
+			// EXCLUDE_YEARS hold for memories too.
+			w := window.intersect(dateRange{After: src.TakenAfter, Before: src.TakenBefore})
+			if w.empty() {
+				continue
+			}
+			src.TakenAfter = w.After
+			src.TakenBefore = w.Before
+			found, _, err := c.fetchPage(src, 1, memoryPageSize)
+			if err != nil {
+				// Try again on the next refresh rather than settle for a
+				// partial day.
+				log.Printf("Memories search for %d years ago in %q failed: %v", yearsAgo, src.Name, err)
+				return
+			}
+			for _, p := range found {
+				if seen[p.ID] {
+					continue
+				}
+				seen[p.ID] = true
+				p.Memory = formatYearsAgo(yearsAgo, localeFor(c.cfg.Locale))
+				photos = append(photos, p)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.memories = photos
+	c.memoryDay = day
+	c.mu.Unlock()
+	log.Printf("Found %d photos taken on this day in the last %d years", len(photos), c.cfg.MemoriesYears)
+}
+
+// pickMemory chooses a random photo from today's memories that has not been
+// shown, queued or excluded yet and is not on screen. It reports false if there is none, so the caller falls
+// back to the regular rotation. Caller must hold c.mu.
+func (c *PhotoCache) pickMemory() (PhotoInfo, bool) {
+	if c.memoryDay != time.Now().Format("2006-01-02") {
+		return PhotoInfo{}, false
+	}
+	var candidates []PhotoInfo
+	for _, p := range c.memories {
+		if !c.shown[p.ID] && !c.queued(p.ID) && !c.onScreen(p.ID) && !c.hidden.has(p.ID) && !c.exclude.tagged[p.ID] && !c.nearDuplicate(p) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return PhotoInfo{}, false
+	}
+	p := candidates[rand.Intn(len(candidates))]
+	log.Printf("Picked a memory from %s (%d of %d left today)", p.Memory, len(candidates)-1, len(c.memories))
+	return p, true
+}
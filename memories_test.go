@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoriesComeFromThisDayInPreviousYears(t *testing.T) {
+	now := time.Now()
+	start := time.Date(now.Year()-1, now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if start.Day() != now.Day() {
+		t.Skip("29 February has no day a year ago")
+	}
+	yearAgo := "iPhone XS|taken:" + dateRange{After: start, Before: start.AddDate(0, 0, 1)}.String()
+	c, fake := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 50, yearAgo: 3})
+	c.cfg.MemoriesPercent = 100
+	c.cfg.MemoriesYears = 2
+	c.cfg.Locale = "en"
+	c.refreshTotal()
+
+	calls := fake.Calls()
+	c.refreshMemories(now)
+	if len(c.memories) != 3 || fake.Calls()-calls != 2 {
+		t.Fatalf("found %d memories in %d searches, want 3 in one per year", len(c.memories), fake.Calls()-calls)
+	}
+	if want := formatYearsAgo(1, localeFor("en")); c.memories[0].Memory != want {
+		t.Errorf("memory labelled %q, want %q", c.memories[0].Memory, want)
+	}
+	c.refreshMemories(now)
+	if fake.Calls()-calls != 2 {
+		t.Error("a second refresh on the same day searched again")
+	}
+
+	// p1 is on screen and p2 excluded by tag, which leaves only p3.
+	c.mu.Lock()
+	c.remember(PhotoInfo{ID: "p1"})
+	c.exclude.tagged = map[string]bool{"p2": true}
+	for i := 0; i < 10; i++ {
+		if p, ok := c.pickMemory(); !ok || p.ID != "p3" {
+			t.Fatalf("picked memory %q (%v), want p3", p.ID, ok)
+		}
+	}
+	c.mu.Unlock()
+
+	if !c.fillQueue() || c.next().ID != "p3" {
+		t.Fatal("MEMORIES_PERCENT=100 did not show the memory first")
+	}
+	// Off screen again, p1 is the one memory left.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.pickMemory(); !ok || p.ID != "p1" {
+		t.Errorf("picked memory %q (%v) after p3 was shown, want p1", p.ID, ok)
+	}
+}
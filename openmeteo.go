@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// openMeteo is the default weather provider. It needs no account or key.
+type openMeteo struct {
+	client  *http.Client
+	baseURL string
+}
+
+func (o *openMeteo) Fetch(loc weatherLocation) (weatherData, error) {
+	url := fmt.Sprintf(
+		"%s/v1/forecast?latitude=%s&longitude=%s&current_weather=true"+
+			"&daily=weathercode,temperature_2m_max,temperature_2m_min,precipitation_probability_max,sunrise,sunset"+
+			"&timezone=auto&forecast_days=%d",
+		o.baseURL, loc.Lat, loc.Lon, forecastDays+1,
+	)
+	if loc.Fahrenheit {
+		url += "&temperature_unit=fahrenheit"
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return weatherData{}, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return weatherData{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return weatherData{}, fmt.Errorf("open-meteo status %d", resp.StatusCode)
+	}
+
+	var data struct {
+		CurrentWeather struct {
+			Temperature float64 `json:"temperature"`
+			WeatherCode int     `json:"weathercode"`
+			IsDay       int     `json:"is_day"`
+		} `json:"current_weather"`
+		// Daily values are parallel arrays, one entry per day from today.
+		Daily struct {
+			Time        []string  `json:"time"`
+			WeatherCode []int     `json:"weathercode"`
+			TempMax     []float64 `json:"temperature_2m_max"`
+			TempMin     []float64 `json:"temperature_2m_min"`
+			PrecipMax   []*int    `json:"precipitation_probability_max"`
+			Sunrise     []string  `json:"sunrise"`
+			Sunset      []string  `json:"sunset"`
+		} `json:"daily"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return weatherData{}, err
+	}
+
+	result := weatherData{
+		Temp:      data.CurrentWeather.Temperature,
+		Condition: wmoCondition(data.CurrentWeather.WeatherCode),
+		IsDay:     data.CurrentWeather.IsDay == 1,
+	}
+
+	d := data.Daily
+	days := len(d.Time)
+	if len(d.WeatherCode) < days || len(d.TempMax) < days || len(d.TempMin) < days ||
+		len(d.PrecipMax) < days || len(d.Sunrise) < days || len(d.Sunset) < days {
+		return weatherData{}, fmt.Errorf("open-meteo returned ragged daily data")
+	}
+	for i := 0; i < days; i++ {
+		date, err := time.Parse("2006-01-02", d.Time[i])
+		if err != nil {
+			return weatherData{}, err
+		}
+		day := weatherDay{
+			Date:      date,
+			Condition: wmoCondition(d.WeatherCode[i]),
+			High:      d.TempMax[i],
+			Low:       d.TempMin[i],
+		}
+		if d.PrecipMax[i] != nil {
+			day.Precip = *d.PrecipMax[i]
+		}
+		result.Days = append(result.Days, day)
+	}
+	if days > 0 {
+		result.Sunrise = clockTime(d.Sunrise[0])
+		result.Sunset = clockTime(d.Sunset[0])
+	}
+	return result, nil
+}
+
+// clockTime cuts the "15:04" out of an ISO local time like "2024-06-01T05:32".
+func clockTime(iso string) string {
+	if i := strings.IndexByte(iso, 'T'); i >= 0 && len(iso) >= i+6 {
+		return iso[i+1 : i+6]
+	}
+	return ""
+}
+
+// wmoCondition maps a WMO weather interpretation code, as open-meteo reports
+// them, to a Condition.
+func wmoCondition(code int) Condition {
+	switch {
+	case code == 0:
+		return ConditionClear
+	case code == 1:
+		return ConditionMostlyClear
+	case code == 2:
+		return ConditionPartlyCloudy
+	case code == 3:
+		return ConditionMostlyCloudy
+	case code == 45 || code == 48:
+		return ConditionFog
+	case code >= 51 && code <= 55:
+		return ConditionDrizzle
+	case code >= 56 && code <= 57:
+		return ConditionSleet
+	case code >= 61 && code <= 63:
+		return ConditionRain
+	case code == 65:
+		return ConditionHeavyRain
+	case code >= 66 && code <= 67:
+		return ConditionWintryMix
+	case code >= 71 && code <= 75:
+		return ConditionSnow
+	case code == 77:
+		return ConditionFlurries
+	case code >= 80 && code <= 82:
+		return ConditionShowers
+	case code >= 85 && code <= 86:
+		return ConditionSnowShowers
+	case code == 95:
+		return ConditionThunderstorm
+	case code == 96 || code == 99:
+		return ConditionSevereThunderstorm
+	default:
+		return ConditionUnknown
+	}
+}
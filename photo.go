@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+// photoSize is the box a photo is fitted into, and the JPEG quality it is
+// re-encoded at.
+type photoSize struct {
+	Width, Height, Quality int
+}
+
+// maxPhotoSide caps requested sizes, so a typo in a URL cannot make the server
+// allocate a gigapixel canvas.
+const maxPhotoSide = 4096
+
+// photoSizeFor works out the size a photo is served at: the frame's screen size
+// if its profile sets one, else the w, h and q query parameters. It reports
+// false when neither asks for resizing and the preview is passed through as is.
+func photoSizeFor(cfg Config, q url.Values) (photoSize, bool) {
+	size := photoSize{Width: cfg.ScreenWidth, Height: cfg.ScreenHeight, Quality: cfg.JPEGQuality}
+	if size.Width == 0 || size.Height == 0 {
+		size.Width, _ = strconv.Atoi(q.Get("w"))
+		size.Height, _ = strconv.Atoi(q.Get("h"))
+		if n, err := strconv.Atoi(q.Get("q")); err == nil {
+			size.Quality = n
+		}
+	}
+	if size.Width <= 0 || size.Height <= 0 {
+		return photoSize{}, false
+	}
+	size.Width = min(size.Width, maxPhotoSide)
+	size.Height = min(size.Height, maxPhotoSide)
+	if size.Quality < 1 || size.Quality > 100 {
+		size.Quality = 85
+	}
+	return size, true
+}
+
+// photoKey identifies a photo rendered at a size in the photo LRU.
+func photoKey(assetID string, size photoSize) string {
+	return fmt.Sprintf("%s@%dx%d/q%d", assetID, size.Width, size.Height, size.Quality)
+}
+
+// resizePhoto decodes an image, turns it upright according to its EXIF
+// orientation, scales it down to fit the box (never up) and re-encodes it as a
+// baseline JPEG, which is what old Mobile Safari decodes fastest and with the
+// least memory. The output carries no EXIF, so nothing can rotate it again.
+func resizePhoto(data []byte, size photoSize) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	src = applyOrientation(src, readOrientation(data))
+
+	b := src.Bounds()
+	scale := math.Min(float64(size.Width)/float64(b.Dx()), float64(size.Height)/float64(b.Dy()))
+	img := src
+	if scale < 1 {
+		w := int(math.Max(1, math.Round(float64(b.Dx())*scale)))
+		h := int(math.Max(1, math.Round(float64(b.Dy())*scale)))
+		img = resample(src, w, h)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: size.Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// contribution is the run of source pixels, and their weights, that make up
+// one destination pixel along an axis.
+type contribution struct {
+	start   int
+	weights []float32
+}
+
+// catmullRom is the Catmull-Rom cubic: sharp, with little ringing, and cheap.
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// contributions computes the filter weights for scaling srcLen pixels to
+// dstLen. When shrinking, the kernel is stretched by the scale factor so every
+// source pixel is accounted for instead of being skipped, which is what keeps
+// a large downscale from aliasing.
+func contributions(srcLen, dstLen int) []contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	filterScale := math.Max(scale, 1)
+	support := 2 * filterScale
+
+	out := make([]contribution, dstLen)
+	for i := range out {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - support))
+		end := int(math.Floor(center + support))
+		if start < 0 {
+			start = 0
+		}
+		if end > srcLen-1 {
+			end = srcLen - 1
+		}
+		weights := make([]float32, end-start+1)
+		var sum float64
+		for j := start; j <= end; j++ {
+			w := catmullRom((float64(j) - center) / filterScale)
+			weights[j-start] = float32(w)
+			sum += w
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] /= float32(sum)
+			}
+		}
+		out[i] = contribution{start: start, weights: weights}
+	}
+	return out
+}
+
+// resample scales an image to w×h with a separable Catmull-Rom filter:
+// horizontally into a float buffer, then vertically into the result.
+func resample(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || rgba.Bounds().Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	}
+	srcW, srcH := b.Dx(), b.Dy()
+
+	xs := contributions(srcW, w)
+	tmp := make([]float32, srcH*w*4)
+	for y := 0; y < srcH; y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x, c := range xs {
+			var r, g, bl, a float32
+			for k, wt := range c.weights {
+				p := row[(c.start+k)*4:]
+				r += wt * float32(p[0])
+				g += wt * float32(p[1])
+				bl += wt * float32(p[2])
+				a += wt * float32(p[3])
+			}
+			t := tmp[(y*w+x)*4:]
+			t[0], t[1], t[2], t[3] = r, g, bl, a
+		}
+	}
+
+	ys := contributions(srcH, h)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, c := range ys {
+		for x := 0; x < w; x++ {
+			var r, g, bl, a float32
+			for k, wt := range c.weights {
+				t := tmp[((c.start+k)*w+x)*4:]
+				r += wt * t[0]
+				g += wt * t[1]
+				bl += wt * t[2]
+				a += wt * t[3]
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = clamp8(r), clamp8(g), clamp8(bl), clamp8(a)
+		}
+	}
+	return dst
+}
+
+// clamp8 rounds a filtered channel back into a byte. Catmull-Rom's negative
+// lobes can overshoot either end slightly.
+func clamp8(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// photoLRU keeps recently resized photos in memory, up to a byte budget, so a
+// photo shown on several frames, or reloaded, is only resized once.
+type photoLRU struct {
+	mu       sync.Mutex
+	capacity int
+	size     int
+	order    *list.List // front is most recently used
+	items    map[string]*list.Element
+}
+
+type photoEntry struct {
+	key  string
+	data []byte
+}
+
+func newPhotoLRU(capacity int) *photoLRU {
+	return &photoLRU{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (l *photoLRU) get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*photoEntry).data, true
+}
+
+func (l *photoLRU) add(key string, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(data) > l.capacity {
+		return
+	}
+	if e, ok := l.items[key]; ok {
+		l.size -= len(e.Value.(*photoEntry).data)
+		l.order.Remove(e)
+	}
+	l.items[key] = l.order.PushFront(&photoEntry{key: key, data: data})
+	l.size += len(data)
+	for l.size > l.capacity {
+		oldest := l.order.Back()
+		entry := oldest.Value.(*photoEntry)
+		l.order.Remove(oldest)
+		delete(l.items, entry.key)
+		l.size -= len(entry.data)
+	}
+}
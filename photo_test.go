@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestResizePhotoFitsBoxAsJPEG(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1440, 1080))
+	for y := 0; y < 1080; y++ {
+		for x := 0; x < 1440; x++ {
+			src.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var in bytes.Buffer
+	jpeg.Encode(&in, src, nil)
+
+	out, err := resizePhoto(in.Bytes(), photoSize{Width: 768, Height: 1024, Quality: 80})
+	if err != nil {
+		t.Fatalf("resizePhoto: %v", err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("output is not a JPEG: %v %q", err, format)
+	}
+	if cfg.Width != 768 || cfg.Height != 576 {
+		t.Errorf("resized to %dx%d, want 768x576", cfg.Width, cfg.Height)
+	}
+}
+
+func TestPhotoLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	l := newPhotoLRU(10)
+	l.add("a", make([]byte, 4))
+	l.add("b", make([]byte, 4))
+	l.get("a")
+	l.add("c", make([]byte, 4))
+
+	if _, ok := l.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if _, ok := l.get("a"); !ok {
+		t.Error("a was used recently and should still be cached")
+	}
+}
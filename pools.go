@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// selectionPool is one pool photos are drawn from: everything, favorites only,
+// or photos rated at least MinRating stars. Percent is its share of the picks.
+type selectionPool struct {
+	Favorites bool
+	MinRating int
+	Percent   int
+}
+
+func (p selectionPool) String() string {
+	switch {
+	case p.Favorites:
+		return "favorites"
+	case p.MinRating > 0:
+		return "rating>=" + strconv.Itoa(p.MinRating)
+	}
+	return "all"
+}
+
+// selectionList is the SELECTION setting: the pools and their shares.
+type selectionList []selectionPool
+
+// parseSelection reads a comma-separated list of pools, each optionally
+// followed by ":<percent>", such as "favorites:50,all:50" or "rating>=4". Pools
+// without a percent split whatever the others leave of 100 equally.
+func parseSelection(v string) (selectionList, error) {
+	var pools selectionList
+	left := 100
+	unweighted := 0
+	for _, entry := range parseList(v) {
+		name, percent, weighted := strings.Cut(entry, ":")
+		var p selectionPool
+		switch name = strings.TrimSpace(name); {
+		case name == "all":
+		case name == "favorites":
+			p.Favorites = true
+		case strings.HasPrefix(name, "rating>="):
+			n, err := strconv.Atoi(strings.TrimPrefix(name, "rating>="))
+			if err != nil || n < 1 || n > 5 {
+				return nil, fmt.Errorf("pool %q: rating must be 1 to 5", name)
+			}
+			p.MinRating = n
+		default:
+			return nil, fmt.Errorf("unknown pool %q: use all, favorites or rating>=N", name)
+		}
+		if weighted {
+			n, err := strconv.Atoi(strings.TrimSpace(percent))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("pool %q: percent must be a positive number", entry)
+			}
+			p.Percent = n
+			left -= n
+		} else {
+			unweighted++
+		}
+		pools = append(pools, p)
+	}
+	if len(pools) == 0 {
+		return nil, nil
+	}
+	if left < 0 || (unweighted == 0 && left != 0) || (unweighted > 0 && left < unweighted) {
+		return nil, fmt.Errorf("pool percentages must add up to 100")
+	}
+	for i := range pools {
+		if pools[i].Percent == 0 {
+			pools[i].Percent = left / unweighted
+		}
+	}
+	return pools, nil
+}
+
+// UnmarshalJSON lets a frame profile give its selection as the same string
+// SELECTION takes.
+func (l *selectionList) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	pools, err := parseSelection(v)
+	if err != nil {
+		return fmt.Errorf("selection: %w", err)
+	}
+	*l = pools
+	return nil
+}
+
+// pools returns the configured pools, or a single pool of everything.
+func (c *PhotoCache) pools() selectionList {
+	if len(c.cfg.Selection) == 0 {
+		return selectionList{{Percent: 100}}
+	}
+	return c.cfg.Selection
+}
+
+// withPools gives every source one copy per pool. Immich filters on an exact
+// rating, so a minimum rating becomes one copy per star count from there up
+// to 5.
+func withPools(sources []Source, pools selectionList) []Source {
+	var out []Source
+	for i, p := range pools {
+		for _, src := range sources {
+			src.Pool = i
+			switch {
+			case p.Favorites:
+				src.Favorite = true
+				src.Name += " (favorites)"
+				out = append(out, src)
+			case p.MinRating > 0:
+				name := src.Name
+				for r := p.MinRating; r <= 5; r++ {
+					src.Rating = r
+					src.Name = fmt.Sprintf("%s (%d stars)", name, r)
+					out = append(out, src)
+				}
+			default:
+				out = append(out, src)
+			}
+		}
+	}
+	return out
+}
+
+// pickSource takes the next slot from a pool's shuffle bag and returns the
+// source it falls in and the photo's offset within that source. The pool is
+// chosen by its share of the picks among those with slots left, so pools keep
+// their proportions while they last and a pool that is used up, or has no
+// photos, gives its share to the rest. When every bag is used up the cycle is
+// over and a new one begins, once pick has let sweep re-offer the photos that
+// shifted past their slots meanwhile. Reports false if there are no photos at
+// all. Caller must hold c.mu.
+func (c *PhotoCache) pickSource() (Source, int, bool) {
+	pools := c.pools()
+	counts := c.poolCounts()
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	renewed := false
+	for {
+		c.fillBags(counts)
+		weight := 0
+		for i, p := range pools {
+			if c.bags[i].Pos < c.bags[i].Size {
+				weight += p.Percent
+			}
+		}
+		if weight == 0 {
+			// Every bag is used up. A cycle that has shown nothing yet has
+			// nothing new to give, so it is not started over, nor is one
+			// whose missed photos sweep has still to find.
+			if renewed || total == 0 || len(c.shown) == 0 || c.sweepDue() {
+				return Source{}, 0, false
+			}
+			c.newCycle()
+			renewed = true
+			continue
+		}
+
+		pool := -1
+		r := rand.Intn(weight)
+		for i, p := range pools {
+			if c.bags[i].Pos >= c.bags[i].Size {
+				continue
+			}
+			if r < p.Percent {
+				pool = i
+				break
+			}
+			r -= p.Percent
+		}
+
+		bag := &c.bags[pool]
+		slot := bag.at(bag.Pos)
+		bag.Pos++
+		for _, src := range c.sources {
+			if src.Pool != pool {
+				continue
+			}
+			n := bag.Counts[src.key()]
+			if slot < n {
+				return src, slot, true
+			}
+			slot -= n
+		}
+		// The slot's source has gone since the bag was filled; try the next.
+	}
+}
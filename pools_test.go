@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"favorites", "favorites:100"},
+		{"favorites:50,all:50", "favorites:50 all:50"},
+		{"rating>=4:30,favorites,all", "rating>=4:30 favorites:35 all:35"},
+	}
+	for _, tt := range tests {
+		pools, err := parseSelection(tt.in)
+		if err != nil {
+			t.Errorf("parseSelection(%q): %v", tt.in, err)
+			continue
+		}
+		got := ""
+		for i, p := range pools {
+			if i > 0 {
+				got += " "
+			}
+			got += fmt.Sprintf("%s:%d", p, p.Percent)
+		}
+		if got != tt.want {
+			t.Errorf("parseSelection(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"best", "rating>=9", "favorites:60,all:60", "favorites:50", "all:0"} {
+		if _, err := parseSelection(bad); err == nil {
+			t.Errorf("parseSelection(%q) accepted", bad)
+		}
+	}
+}
+
+func TestPoolsAreDrawnInProportion(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 1000, "iPhone XS|favorites": 200})
+	c.cfg.Selection, _ = parseSelection("favorites:50,all:50")
+	c.refreshTotal()
+
+	if got := c.totalPages(); got != 1000 {
+		t.Errorf("totalPages() = %d, want only the all pool's 1000", got)
+	}
+	// While both pools have photos left they share the picks.
+	favorites := 0
+	for i := 0; i < 200; i++ {
+		if src, _, _ := c.pickSource(); src.Favorite {
+			favorites++
+		}
+	}
+	if favorites < 70 || favorites > 130 {
+		t.Errorf("%d of 200 picks were favorites, want about half", favorites)
+	}
+
+	// Once the favorites are used up, the rest of the cycle is the all pool.
+	for i := 200; i < 1200; i++ {
+		if src, _, _ := c.pickSource(); src.Favorite {
+			favorites++
+		}
+	}
+	if favorites != 200 {
+		t.Errorf("%d favorites picked in a cycle, want each of the 200 once", favorites)
+	}
+}
+
+func TestMinimumRatingCoversEachStarCount(t *testing.T) {
+	c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS|rating:4": 3, "iPhone XS|rating:5": 2, "iPhone XS|rating:3": 7})
+	c.cfg.Selection, _ = parseSelection("rating>=4")
+	c.refreshTotal()
+
+	if got := c.totalPages(); got != 5 {
+		t.Errorf("totalPages() = %d, want the 5 photos rated 4 or 5", got)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// nextWait is how long next waits for the prefetcher when the queue has
+	// run dry, kept well under the page's 15-second request timeout.
+	nextWait = 10 * time.Second
+	// prefetchRetry is how long the prefetcher backs off after a pick that
+	// came back empty, e.g. while Immich is still starting.
+	prefetchRetry = 10 * time.Second
+)
+
+// wakePrefetcher tells the prefetcher the queue may need topping up.
+func (c *PhotoCache) wakePrefetcher() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startPrefetcher keeps PREFETCH_SIZE photos queued in the background, with
+// their details already fetched, so next only ever pops from the queue and a
+// /random request never waits on Immich.
+func (c *PhotoCache) startPrefetcher() {
+	go func() {
+		for {
+			c.mu.Lock()
+			full := len(c.queue) >= c.cfg.PrefetchSize
+			c.mu.Unlock()
+
+			if full {
+				<-c.wake
+				continue
+			}
+			if !c.fillQueue() {
+				select {
+				case <-c.wake:
+				case <-time.After(prefetchRetry):
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// remoteCommand is one instruction for a frame's page. previous and show carry
+// the photo to put up, so the page does not have to ask for it.
+type remoteCommand struct {
+	Seq    int        `json:"seq"`
+	Action string     `json:"action"`
+	Photo  *PhotoInfo `json:"photo,omitempty"`
+}
+
+const (
+	// remoteBacklog is how many commands a page that briefly lost its
+	// connection can catch up on.
+	remoteBacklog = 20
+	// remotePoll is how long a /commands request is held open, well inside
+	// the page's own request timeout.
+	remotePoll = 25 * time.Second
+)
+
+// remote is a frame's command channel. POST /control/<action> queues a command;
+// the page long-polls GET /commands, which works on iOS 5 where server-sent
+// events do not.
+type remote struct {
+	mu       sync.Mutex
+	seq      int
+	commands []remoteCommand
+	// changed is closed and replaced whenever a command is added, waking
+	// every waiting poll.
+	changed chan struct{}
+}
+
+func newRemote() *remote {
+	return &remote{changed: make(chan struct{})}
+}
+
+func (r *remote) send(action string, photo *PhotoInfo) remoteCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	cmd := remoteCommand{Seq: r.seq, Action: action, Photo: photo}
+	r.commands = append(r.commands, cmd)
+	if len(r.commands) > remoteBacklog {
+		r.commands = r.commands[len(r.commands)-remoteBacklog:]
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return cmd
+}
+
+// since returns the commands after seq, the latest seq, and a channel that is
+// closed when another command arrives.
+func (r *remote) since(seq int) ([]remoteCommand, int, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []remoteCommand
+	for _, cmd := range r.commands {
+		if cmd.Seq > seq {
+			out = append(out, cmd)
+		}
+	}
+	return out, r.seq, r.changed
+}
+
+// handleCommands long-polls for a frame's commands after ?since=. A page that
+// has just loaded passes since=-1 and gets the current seq straight back, so it
+// does not replay commands meant for its previous life. A since beyond the
+// current seq, left over from before a server restart, is treated the same.
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	f, ok := s.frame(w, r)
+	if !ok {
+		return
+	}
+	since, err := strconv.Atoi(r.URL.Query().Get("since"))
+	if err != nil {
+		since = -1
+	}
+
+	cmds, seq, changed := f.remote.since(since)
+	if since > seq {
+		// The server restarted and its sequence began again.
+		since = -1
+	}
+	if since >= 0 && len(cmds) == 0 {
+		select {
+		case <-changed:
+			cmds, seq, _ = f.remote.since(since)
+		case <-time.After(remotePoll):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if since < 0 {
+		cmds = nil
+	}
+	if cmds == nil {
+		cmds = []remoteCommand{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	json.NewEncoder(w).Encode(map[string]interface{}{"seq": seq, "commands": cmds})
+}
+
+// handleControl takes a command for a frame: POST /control/pause, /resume,
+// /next, /previous, or /show?id=<asset>.
+func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := s.frame(w, r)
+	if !ok {
+		return
+	}
+
+	action := r.URL.Path[len("/control/"):]
+	var photo *PhotoInfo
+	switch action {
+	case "pause", "resume", "next":
+	case "previous":
+		photo = f.cache.previous()
+		if photo == nil {
+			http.Error(w, "No earlier photo", http.StatusConflict)
+			return
+		}
+	case "show":
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id", http.StatusBadRequest)
+			return
+		}
+		p, err := s.fetchAsset(r.Context(), id, localeFor(f.cfg.Locale))
+		if err != nil {
+			log.Printf("Show asset %s error: %v", id, err)
+			http.Error(w, "Asset not available", immichStatus(err))
+			return
+		}
+		f.cache.showing(p)
+		photo = &p
+	default:
+		http.Error(w, "Unknown command", http.StatusNotFound)
+		return
+	}
+
+	cmd := f.remote.send(action, photo)
+	log.Printf("Frame %q: remote %s", f.Name, action)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmd)
+}
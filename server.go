@@ -1,17 +1,28 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 	"text/template"
+
+	"immich-ipad/immich"
 )
 
 type Server struct {
-	cfg    Config
-	client *http.Client
-	cache  *PhotoCache
+	cfg Config
+	api *immich.Client
+	// frames holds every display profile by name; "" is the default frame.
+	frames map[string]*Frame
+	// photos caches resized photos by asset ID and size.
+	photos *photoLRU
+	tiles  *tileCache
+	// weather keeps the latest reading for every frame's location.
+	weather *weatherService
+	// hidden is the denylist shared by every frame.
+	hidden *hiddenList
 	tmpl   *template.Template
 }
 
@@ -22,6 +33,10 @@ func (s *Server) routes() {
 	http.HandleFunc("/map", s.handleMap)
 	http.HandleFunc("/weather", s.handleWeather)
 	http.HandleFunc("/weather-icon/", s.handleWeatherIcon)
+	http.HandleFunc("/commands", s.handleCommands)
+	http.HandleFunc("/control/", s.handleControl)
+	http.HandleFunc("/hide", s.handleHide)
+	http.HandleFunc("/favorite", s.handleFavorite)
 }
 
 type locationInfo struct {
@@ -30,49 +45,104 @@ type locationInfo struct {
 	Lon  float64
 }
 
-func (s *Server) fetchLocation(assetID string) locationInfo {
-	req, err := http.NewRequest("GET", s.cfg.ImmichURL+"/api/assets/"+assetID, nil)
-	if err != nil {
-		return locationInfo{}
-	}
-	req.Header.Set("x-api-key", s.cfg.ImmichAPIKey)
-
-	resp, err := s.client.Do(req)
+// fetchLocation looks up where a photo was taken. Failures leave the location
+// blank: the overlay simply shows no city.
+func (s *Server) fetchLocation(ctx context.Context, assetID string) locationInfo {
+	p, err := s.fetchAsset(ctx, assetID, nil)
 	if err != nil {
 		log.Printf("Location fetch error for %s: %v", assetID, err)
 		return locationInfo{}
 	}
-	defer resp.Body.Close()
+	return locationInfo{City: p.City, Lat: p.Lat, Lon: p.Lon}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return locationInfo{}
+// fetchAsset loads one asset's details from Immich. The date is only filled in
+// when a locale is given.
+func (s *Server) fetchAsset(ctx context.Context, assetID string, loc *locale) (PhotoInfo, error) {
+	asset, err := s.api.Asset(ctx, assetID)
+	if err != nil {
+		return PhotoInfo{}, err
 	}
 
-	var asset struct {
-		ExifInfo struct {
-			City      string   `json:"city"`
-			State     string   `json:"state"`
-			Country   string   `json:"country"`
-			Latitude  *float64 `json:"latitude"`
-			Longitude *float64 `json:"longitude"`
-		} `json:"exifInfo"`
+	p := PhotoInfo{ID: assetID, cityDone: true}
+	if exif := asset.ExifInfo; exif != nil {
+		parts := []string{}
+		if exif.City != "" {
+			parts = append(parts, exif.City)
+		}
+		if exif.Country != "" {
+			parts = append(parts, exif.Country)
+		}
+		p.City = strings.Join(parts, ", ")
+		if exif.Latitude != nil && exif.Longitude != nil {
+			p.Lat = *exif.Latitude
+			p.Lon = *exif.Longitude
+		}
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&asset); err != nil {
-		return locationInfo{}
+	if loc != nil {
+		p.Date = formatDate(asset.FileCreatedAt, loc)
 	}
+	return p, nil
+}
 
-	parts := []string{}
-	if asset.ExifInfo.City != "" {
-		parts = append(parts, asset.ExifInfo.City)
+// fetchThumbnail downloads an asset's preview-size thumbnail, returning its
+// bytes and content type.
+func (s *Server) fetchThumbnail(ctx context.Context, assetID string) ([]byte, string, error) {
+	return s.api.Thumbnail(ctx, assetID, "preview")
+}
+
+// immichStatus is the status a handler answers with when a call to Immich
+// fails: 404 for a missing asset, 502 for anything else, since the frame
+// itself is fine.
+func immichStatus(err error) int {
+	if errors.Is(err, immich.ErrNotFound) {
+		return http.StatusNotFound
 	}
-	if asset.ExifInfo.Country != "" {
-		parts = append(parts, asset.ExifInfo.Country)
+	if errors.Is(err, immich.ErrAuth) {
+		log.Printf("Immich rejected the API key; check IMMICH_API_KEY and its permissions")
 	}
+	return http.StatusBadGateway
+}
+
+// enricher returns the hook a frame's prefetcher runs on each photo before
+// queueing it: the city lookup, when the frame's screen size is known up front
+// the resized image too, and with PERCEPTUAL_HASH the photo's hash.
+func (s *Server) enricher(f *Frame) func(*PhotoInfo) {
+	return func(p *PhotoInfo) {
+		loc := s.fetchLocation(context.Background(), p.ID)
+		p.City = loc.City
+		p.Lat = loc.Lat
+		p.Lon = loc.Lon
+		p.cityDone = true
 
-	loc := locationInfo{City: strings.Join(parts, ", ")}
-	if asset.ExifInfo.Latitude != nil && asset.ExifInfo.Longitude != nil {
-		loc.Lat = *asset.ExifInfo.Latitude
-		loc.Lon = *asset.ExifInfo.Longitude
+		size, resize := photoSizeFor(f.cfg, nil)
+		key := photoKey(p.ID, size)
+		var data []byte
+		if resize {
+			if cached, ok := s.photos.get(key); ok {
+				data, resize = cached, false
+			}
+		}
+		if !resize && !f.cfg.PerceptualHash {
+			return
+		}
+		if data == nil {
+			var err error
+			if data, _, err = s.fetchThumbnail(context.Background(), p.ID); err != nil {
+				log.Printf("Photo prefetch error for %s: %v", p.ID, err)
+				return
+			}
+		}
+		if f.cfg.PerceptualHash {
+			// Any rendition will do: the hash is taken at 9×8 pixels.
+			if h, err := dHash(data); err == nil {
+				p.hash, p.hashed = h, true
+			}
+		}
+		if resize {
+			if resized, err := resizePhoto(data, size); err == nil {
+				s.photos.add(key, resized)
+			}
+		}
 	}
-	return loc
 }
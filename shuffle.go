@@ -0,0 +1,216 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"immich-ipad/immich"
+)
+
+// shuffleBag is one pool's playing order for the current cycle: a permutation
+// of its Size slots, one per photo, and how far through it playback has got.
+// Counts holds each source's photo count when the bag was filled, which maps
+// slots to sources for the whole cycle. Uploads found by refreshUploads are
+// appended to the index, so they do not shift the others; a daily rebuild of
+// the index, or any change to Immich's results outside INDEX_MODE, does, and
+// sweep finds what was missed. The permutation is generated from Seed rather
+// than stored, so a bag is saved as a few numbers however large the library.
+type shuffleBag struct {
+	Seed   int64          `json:"seed"`
+	Size   int            `json:"size"`
+	Pos    int            `json:"pos"`
+	Counts map[string]int `json:"counts"`
+}
+
+// at returns slot i of the permutation. It is a four-round Feistel network
+// over the smallest power of four at least Size, walked until it lands inside
+// the bag: a bijection that needs no table.
+func (b shuffleBag) at(i int) int {
+	bits := 2
+	for 1<<bits < b.Size {
+		bits += 2
+	}
+	half := bits / 2
+	mask := uint64(1)<<half - 1
+	x := uint64(i)
+	for {
+		l, r := x>>half, x&mask
+		for round := uint64(0); round < 4; round++ {
+			l, r = r, l^(mix(uint64(b.Seed)+round, r)&mask)
+		}
+		x = l<<half | r
+		if x < uint64(b.Size) {
+			return int(x)
+		}
+	}
+}
+
+// mix is the SplitMix64 finaliser applied to a and b, a cheap hash good
+// enough to scramble bag slots and derive seeds.
+func mix(a, b uint64) uint64 {
+	z := a*0x9e3779b97f4a7c15 + b
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// newSeed returns SHUFFLE_SEED if set, else a random seed.
+func newSeed(cfg Config) int64 {
+	if cfg.ShuffleSeed != 0 {
+		return cfg.ShuffleSeed
+	}
+	return rand.Int63()
+}
+
+// poolCounts returns how many photos each pool holds. Caller must hold c.mu.
+func (c *PhotoCache) poolCounts() []int {
+	counts := make([]int, len(c.pools()))
+	for _, src := range c.sources {
+		counts[src.Pool] += c.maxPages[src.key()]
+	}
+	return counts
+}
+
+// fillBags makes sure every pool has a bag. A bag not started yet is sized to
+// its pool's current counts, so a pool whose count was unknown when the cycle
+// began joins in once it is known. A started bag keeps its counts for the rest
+// of the cycle: photos added since wait for the next one, and slots past the
+// end of a source that has shrunk are skipped. Caller must hold c.mu.
+func (c *PhotoCache) fillBags(counts []int) {
+	if len(c.bags) != len(counts) {
+		c.bags = make([]shuffleBag, len(counts))
+	}
+	for i, n := range counts {
+		b := &c.bags[i]
+		if (b.Pos > 0 || b.Size == n) && b.Counts != nil {
+			continue
+		}
+		b.Seed = int64(mix(uint64(c.seed)+uint64(c.cycle), uint64(i)) >> 1)
+		b.Size = n
+		b.Pos = 0
+		b.Counts = make(map[string]int)
+		for _, src := range c.sources {
+			if src.Pool == i {
+				b.Counts[src.key()] = c.maxPages[src.key()]
+			}
+		}
+	}
+}
+
+// putBack returns the slot just taken from a pool's bag, so it is drawn again
+// next. Only the prefetcher picks, so it is still the last one taken. Caller
+// must hold c.mu.
+func (c *PhotoCache) putBack(pool int) {
+	if pool < len(c.bags) && c.bags[pool].Pos > 0 {
+		c.bags[pool].Pos--
+	}
+}
+
+// newCycle starts the no-repeat cycle over: the shown set is cleared and every
+// pool gets a freshly shuffled bag. Near-duplicates still put off are skipped
+// for this cycle, as BURST_SECONDS and the like ask. Caller must hold c.mu.
+func (c *PhotoCache) newCycle() {
+	log.Printf("All %d photos shown since %s (%d near-duplicates skipped), starting cycle %d",
+		len(c.shown), c.cycleStart.Format(time.RFC3339), len(c.deferred), c.cycle+1)
+	c.shown = make(map[string]bool)
+	c.cycleStart = time.Now()
+	c.cycle++
+	c.bags = nil
+	c.deferred = nil
+	c.swept = false
+}
+
+// sweepDue reports whether the cycle has played every bag to the end and not
+// swept yet. Caller must hold c.mu.
+func (c *PhotoCache) sweepDue() bool {
+	if c.swept || len(c.bags) == 0 {
+		return false
+	}
+	for _, b := range c.bags {
+		if b.Pos < b.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// sweep finds the photos the cycle missed. A slot is an offset into a
+// source's photos in some order: Immich's search results, where each upload
+// or deletion shifts every photo after it by a page, or in INDEX_MODE the
+// index, which the daily rebuild lays out afresh. Either way a photo can move
+// onto a slot already played and never come up. Once every bag is used up,
+// sweep goes through the sources, from the index or a thousand photos at a
+// time from Immich, and puts off whatever is not shown yet, so the cycle ends
+// only when each photo was offered once. It reports false if Immich could not
+// be asked; the sweep is tried again on the next pick.
+func (c *PhotoCache) sweep() bool {
+	loc := localeFor(c.cfg.Locale)
+	c.mu.Lock()
+	sources := c.sources
+	// Near-duplicates put off already are in the list; they are not missed.
+	seen := make(map[string]bool)
+	for _, p := range c.deferred {
+		seen[p.ID] = true
+	}
+	c.mu.Unlock()
+
+	var missed []PhotoInfo
+	// offer keeps a photo the cycle has not shown. Caller must hold c.mu.
+	offer := func(e indexEntry) {
+		if seen[e.ID] {
+			return
+		}
+		seen[e.ID] = true
+		if !c.shown[e.ID] && !c.queued(e.ID) && !c.onScreen(e.ID) && !c.hidden.has(e.ID) && !c.exclude.tagged[e.ID] {
+			missed = append(missed, e.photo(loc))
+		}
+	}
+	for _, src := range sources {
+		if c.cfg.IndexMode {
+			c.mu.Lock()
+			for _, e := range c.index[src.key()].Entries {
+				offer(e)
+			}
+			c.mu.Unlock()
+			continue
+		}
+		_, err := c.walk(src, time.Time{}, func(a immich.Asset) {
+			c.mu.Lock()
+			offer(entryFor(a))
+			c.mu.Unlock()
+		})
+		if err != nil {
+			log.Printf("Looking for photos the cycle missed in %q failed: %v", src.Name, err)
+			return false
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deferred = append(c.deferred, missed...)
+	c.swept = true
+	if len(missed) > 0 {
+		log.Printf("Found %d photos the cycle missed, showing them before it ends", len(missed))
+	}
+	return true
+}
+
+// takeDeferred returns a near-duplicate put off earlier that no longer
+// resembles anything recently shown, so photos set aside to keep bursts apart
+// are still shown once in the cycle. Caller must hold c.mu.
+func (c *PhotoCache) takeDeferred() (PhotoInfo, bool) {
+	for i := 0; i < len(c.deferred); i++ {
+		p := c.deferred[i]
+		if c.shown[p.ID] || c.queued(p.ID) || c.onScreen(p.ID) || c.hidden.has(p.ID) {
+			c.deferred = append(c.deferred[:i], c.deferred[i+1:]...)
+			i--
+			continue
+		}
+		if !c.nearDuplicate(p) {
+			c.deferred = append(c.deferred[:i], c.deferred[i+1:]...)
+			return p, true
+		}
+	}
+	return PhotoInfo{}, false
+}
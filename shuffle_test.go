@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShuffleBagIsAPermutation(t *testing.T) {
+	for _, size := range []int{1, 2, 7, 1000, 4097} {
+		b := shuffleBag{Seed: 42, Size: size}
+		seen := make([]bool, size)
+		for i := 0; i < size; i++ {
+			slot := b.at(i)
+			if slot < 0 || slot >= size || seen[slot] {
+				t.Fatalf("size %d: slot %d = %d, repeated or out of range", size, i, slot)
+			}
+			seen[slot] = true
+		}
+	}
+
+	order := func(seed int64) string {
+		b := shuffleBag{Seed: seed, Size: 10}
+		var s []int
+		for i := 0; i < b.Size; i++ {
+			s = append(s, b.at(i))
+		}
+		return fmt.Sprint(s)
+	}
+	if order(1) != order(1) {
+		t.Error("the same seed gave two orders")
+	}
+	if order(1) == order(2) {
+		t.Errorf("seeds 1 and 2 both gave %s", order(1))
+	}
+}
+
+func TestCycleEndsWhenEveryPhotoWasOffered(t *testing.T) {
+	newCache := func(dir string) *PhotoCache {
+		c, _ := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 20})
+		c.cfg.ShuffleSeed = 7
+		c.cfg.StateDir = dir
+		c.seed = newSeed(c.cfg)
+		c.hidden = newHiddenList(Config{}, nil)
+		c.hidden.hide("p3")
+		c.hidden.hide("p11")
+		c.refreshTotal()
+		return c
+	}
+	show := func(c *PhotoCache, n int) []string {
+		var ids []string
+		for i := 0; i < n; i++ {
+			if !c.fillQueue() {
+				t.Fatalf("nothing picked after %d photos", i)
+			}
+			ids = append(ids, c.next().ID)
+		}
+		return ids
+	}
+
+	dir := t.TempDir()
+	c := newCache(dir)
+	first := show(c, 10)
+	if err := c.saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	// A restart carries on through the same order.
+	resumed := newCache(dir)
+	if err := resumed.loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	rest := show(resumed, 8)
+	if want := show(c, 8); fmt.Sprint(rest) != fmt.Sprint(want) {
+		t.Errorf("after restart showed %v, want %v", rest, want)
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range append(first, rest...) {
+		if seen[id] || id == "p3" || id == "p11" {
+			t.Fatalf("cycle showed %s twice or while hidden: %v", id, append(first, rest...))
+		}
+		seen[id] = true
+	}
+
+	// Two photos are hidden, so the cycle is over after 18 rather than
+	// waiting for a 20th that never comes.
+	show(resumed, 1)
+	if resumed.cycle != 1 || len(resumed.shown) != 1 {
+		t.Errorf("after 19 photos: cycle %d with %d shown, want a new cycle with 1", resumed.cycle, len(resumed.shown))
+	}
+}
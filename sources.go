@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"immich-ipad/immich"
+)
+
+// Source is one slice of the library the frame draws photos from: everything a
+// device model took, or everything in an album, optionally narrowed down to
+// photos containing certain people.
+type Source struct {
+	Model   string
+	AlbumID string
+	// PersonIDs limits the source to assets showing all of these people.
+	// PersonNames holds the configured names, which unlike the IDs are stable
+	// enough to key page counts by.
+	PersonIDs   []string
+	PersonNames []string
+	// TakenAfter and TakenBefore, when set, limit the source to photos taken
+	// in that window.
+	TakenAfter  time.Time
+	TakenBefore time.Time
+	// Favorite and Rating limit the source to favorites, or to photos with
+	// exactly Rating stars. Pool is the SELECTION pool the source belongs to.
+	Favorite bool
+	Rating   int
+	Pool     int
+	// Name is how the source appears in logs: the model or album name, plus
+	// the people it is limited to.
+	Name string
+}
+
+// key identifies the source in maxPages. Models are keyed by their bare name so
+// counts stay comparable with what DEVICE_MODELS lists.
+func (s Source) key() string {
+	k := s.Model
+	if s.AlbumID != "" {
+		k = "album:" + s.AlbumID
+	}
+	if len(s.PersonNames) > 0 {
+		k += "|people:" + strings.Join(s.PersonNames, "+")
+	}
+	if !s.TakenAfter.IsZero() || !s.TakenBefore.IsZero() {
+		k += "|taken:" + dateRange{s.TakenAfter, s.TakenBefore}.String()
+	}
+	if s.Favorite {
+		k += "|favorites"
+	}
+	if s.Rating > 0 {
+		k += "|rating:" + strconv.Itoa(s.Rating)
+	}
+	return k
+}
+
+// filters adds the source's search filters to a metadata search.
+func (s Source) filters(q *immich.MetadataQuery) {
+	q.Model = s.Model
+	if s.AlbumID != "" {
+		q.AlbumIDs = []string{s.AlbumID}
+	}
+	q.PersonIDs = s.PersonIDs
+	if s.Favorite {
+		favorite := true
+		q.IsFavorite = &favorite
+	}
+	q.Rating = s.Rating
+	if !s.TakenAfter.IsZero() {
+		t := s.TakenAfter.UTC()
+		q.TakenAfter = &t
+	}
+	if !s.TakenBefore.IsZero() {
+		t := s.TakenBefore.UTC()
+		q.TakenBefore = &t
+	}
+}
+
+// withPeople returns a copy of the source limited to the given people.
+func (s Source) withPeople(people []immich.Person) Source {
+	s.PersonIDs = nil
+	s.PersonNames = nil
+	for _, p := range people {
+		s.PersonIDs = append(s.PersonIDs, p.ID)
+		s.PersonNames = append(s.PersonNames, p.Name)
+	}
+	s.Name += " with " + strings.Join(s.PersonNames, " & ")
+	return s
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// findPerson looks a person up by name. Immich's person search matches
+// prefixes, so only an exact (case-insensitive) match counts: "Ali" must not
+// quietly become "Alice".
+func (c *PhotoCache) findPerson(name string) (immich.Person, bool, error) {
+	people, err := c.api.SearchPeople(context.Background(), name)
+	if err != nil {
+		return immich.Person{}, false, err
+	}
+	for _, p := range people {
+		if strings.EqualFold(p.Name, name) {
+			return p, true, nil
+		}
+	}
+	return immich.Person{}, false, nil
+}
+
+// resolvePeople looks up the configured PEOPLE names. A name that matches no
+// one is logged and left out, but if none match at all that is an error: an
+// empty filter would quietly put the whole library back in the rotation.
+func (c *PhotoCache) resolvePeople() ([]immich.Person, error) {
+	var people []immich.Person
+	for _, name := range c.cfg.People {
+		p, ok, err := c.findPerson(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("Person %q not found in Immich, skipping", name)
+			continue
+		}
+		p.Name = name
+		people = append(people, p)
+	}
+	if len(people) == 0 {
+		return nil, fmt.Errorf("none of the configured people were found")
+	}
+	return people, nil
+}
+
+// resolveSources turns the configured models and albums into sources. Albums
+// may be given by ID or by name; names are looked up through the albums API,
+// and an album that cannot be found is logged and left out.
+//
+// With PEOPLE set, every source is narrowed to those people. Immich's person
+// filter means "all of", so that mode narrows each source once; "any of" is
+// one source per person instead, and a photo showing two of them is counted
+// (and so weighted) in both. Finally each source is split into the SELECTION
+// pools.
+func (c *PhotoCache) resolveSources() ([]Source, error) {
+	sources, err := c.resolveBaseSources()
+	if err != nil {
+		return nil, err
+	}
+	if len(c.cfg.People) == 0 {
+		return withPools(sources, c.pools()), nil
+	}
+
+	people, err := c.resolvePeople()
+	if err != nil {
+		return nil, err
+	}
+	var narrowed []Source
+	for _, src := range sources {
+		if c.cfg.PeopleMode == "all" {
+			narrowed = append(narrowed, src.withPeople(people))
+			continue
+		}
+		for _, p := range people {
+			narrowed = append(narrowed, src.withPeople([]immich.Person{p}))
+		}
+	}
+	return withPools(narrowed, c.pools()), nil
+}
+
+// resolveBaseSources turns each DEVICE_MODELS and ALBUMS entry into sources,
+// split by date as withDates describes. An entry may carry its own range after
+// an "@", as in "iPhone XS@2019..".
+func (c *PhotoCache) resolveBaseSources() ([]Source, error) {
+	var sources []Source
+	for _, entry := range c.cfg.DeviceModels {
+		model, r, _, err := splitDateRange(entry)
+		if err != nil {
+			log.Printf("Device model %q: %v, skipping", entry, err)
+			continue
+		}
+		sources = append(sources, c.withDates(Source{Model: model, Name: model}, r)...)
+	}
+	if len(c.cfg.Albums) == 0 {
+		return sources, nil
+	}
+
+	albums, err := c.api.Albums(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]string)
+	byName := make(map[string]string)
+	for _, a := range albums {
+		byID[a.ID] = a.AlbumName
+		byName[a.AlbumName] = a.ID
+	}
+
+	for _, entry := range c.cfg.Albums {
+		album, r, ranged, err := splitDateRange(entry)
+		if err != nil {
+			log.Printf("Album %q: %v, skipping", entry, err)
+			continue
+		}
+		if ranged && !uuidPattern.MatchString(album) && byName[album] == "" && byName[entry] != "" {
+			// An album whose name merely looks like it has a range.
+			album, r = entry, dateRange{}
+		}
+		if uuidPattern.MatchString(album) {
+			name := byID[album]
+			if name == "" {
+				name = album
+			}
+			sources = append(sources, c.withDates(Source{AlbumID: album, Name: name}, r)...)
+			continue
+		}
+		id, ok := byName[album]
+		if !ok {
+			log.Printf("Album %q not found in Immich, skipping", album)
+			continue
+		}
+		sources = append(sources, c.withDates(Source{AlbumID: id, Name: album}, r)...)
+	}
+	return sources, nil
+}
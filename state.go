@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheState is what a PhotoCache keeps across restarts: the no-repeat cycle,
+// with the shuffle bags it is playing through, and the page counts, so a
+// reboot neither replays photos nor starts every page count search from
+// scratch.
+type cacheState struct {
+	Shown      []string       `json:"shown"`
+	MaxPages   map[string]int `json:"maxPages"`
+	CycleStart time.Time      `json:"cycleStart"`
+	Seed       int64          `json:"seed"`
+	Cycle      int            `json:"cycle"`
+	Bags       []shuffleBag   `json:"bags"`
+	SavedAt    time.Time      `json:"savedAt"`
+}
+
+// statePath returns where the cache state lives, or "" if STATE_DIR is unset
+// and nothing should be persisted.
+func (c *PhotoCache) statePath() string {
+	if c.cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(c.cfg.StateDir, "state.json")
+}
+
+// loadState restores a snapshot written by saveState. A missing file is not an
+// error: that is simply the first boot.
+func (c *PhotoCache) loadState() error {
+	path := c.statePath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st cacheState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range st.Shown {
+		c.shown[id] = true
+	}
+	for key, n := range st.MaxPages {
+		c.maxPages[key] = n
+	}
+	if !st.CycleStart.IsZero() {
+		c.cycleStart = st.CycleStart
+	}
+	// A changed SHUFFLE_SEED starts a new order; the shown set still keeps
+	// this cycle free of repeats.
+	if st.Seed != 0 && (c.cfg.ShuffleSeed == 0 || c.cfg.ShuffleSeed == st.Seed) {
+		c.seed = st.Seed
+		c.cycle = st.Cycle
+		c.bags = st.Bags
+	}
+	log.Printf("Restored state from %s: %d shown, %d page counts, cycle started %s",
+		path, len(st.Shown), len(st.MaxPages), c.cycleStart.Format(time.RFC3339))
+	return nil
+}
+
+// saveState writes a snapshot of the cache state with writeFileAtomic, so a
+// power cut mid-write leaves the previous snapshot intact rather than a
+// truncated one.
+func (c *PhotoCache) saveState() error {
+	path := c.statePath()
+	if path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	st := cacheState{
+		Shown:      make([]string, 0, len(c.shown)),
+		MaxPages:   make(map[string]int, len(c.maxPages)),
+		CycleStart: c.cycleStart,
+		Seed:       c.seed,
+		Cycle:      c.cycle,
+		Bags:       append([]shuffleBag(nil), c.bags...),
+		SavedAt:    time.Now(),
+	}
+	for id := range c.shown {
+		st.Shown = append(st.Shown, id)
+	}
+	for key, n := range c.maxPages {
+		st.MaxPages[key] = n
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes a file by way of a temporary one renamed into place.
+// The data is synced to disk before the rename: otherwise ext4 and overlayfs
+// may commit the rename first, and a power cut leaves an empty file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// startSaveLoop snapshots the state every minute. Shutdown saves once more, but
+// a power cut gives no warning, so this bounds how much of the cycle is lost.
+func (c *PhotoCache) startSaveLoop() {
+	if c.statePath() == "" {
+		return
+	}
+	go func() {
+		for range time.NewTicker(1 * time.Minute).C {
+			if err := c.saveState(); err != nil {
+				log.Printf("State save error: %v", err)
+			}
+		}
+	}()
+}
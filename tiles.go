@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tileCache fetches map tiles from MAP_TILE_URL and keeps them on disk, so each
+// tile is downloaded once per TTL instead of once per photo, as the OSM tile
+// usage policy asks, and maps keep working from cache when the internet is
+// down. Without a directory it fetches every tile, as before.
+type tileCache struct {
+	dir       string
+	maxBytes  int64
+	ttl       time.Duration
+	urlFormat string
+	userAgent string
+	client    *http.Client
+
+	mu    sync.Mutex
+	bytes int64 // current size of dir, -1 until first measured
+}
+
+func newTileCache(cfg Config, client *http.Client) *tileCache {
+	return &tileCache{
+		dir:       cfg.TileCacheDir,
+		maxBytes:  int64(cfg.TileCacheMB) << 20,
+		ttl:       time.Duration(cfg.TileCacheDays) * 24 * time.Hour,
+		urlFormat: cfg.MapTileURL,
+		userAgent: cfg.MapUserAgent,
+		client:    client,
+		bytes:     -1,
+	}
+}
+
+// tileURL fills the {z}, {x} and {y} placeholders of the tile URL template.
+func (t *tileCache) tileURL(z, x, y int) string {
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(z),
+		"{x}", strconv.Itoa(x),
+		"{y}", strconv.Itoa(y),
+	).Replace(t.urlFormat)
+}
+
+// get returns a decoded tile. A cached copy younger than the TTL is used as is;
+// an older one is refreshed, but still used if the refresh fails.
+func (t *tileCache) get(z, x, y int) (image.Image, error) {
+	data, err := t.load(z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func (t *tileCache) load(z, x, y int) ([]byte, error) {
+	url := t.tileURL(z, x, y)
+	if path, ok := strings.CutPrefix(url, "file://"); ok {
+		// Local tiles need no cache of their own.
+		return os.ReadFile(path)
+	}
+	if t.dir == "" {
+		return t.fetch(url)
+	}
+
+	path := filepath.Join(t.dir, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y))
+	cached, statErr := os.Stat(path)
+	if statErr == nil && time.Since(cached.ModTime()) < t.ttl {
+		if data, err := os.ReadFile(path); err == nil {
+			return data, nil
+		}
+	}
+
+	data, err := t.fetch(url)
+	if err != nil {
+		if statErr == nil {
+			if stale, readErr := os.ReadFile(path); readErr == nil {
+				return stale, nil
+			}
+		}
+		return nil, err
+	}
+	if err := t.store(path, data); err != nil {
+		log.Printf("Tile cache write error: %v", err)
+	}
+	return data, nil
+}
+
+func (t *tileCache) fetch(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", t.userAgent)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tile server status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// tempPrefix starts the name of a tile still being written. Each write has a
+// temporary file of its own, so two frames fetching the same tile at once do
+// not write into the same one.
+const tempPrefix = ".tile-"
+
+// store writes a tile into the cache and trims the cache if that took it over
+// its size cap.
+func (t *tileCache) store(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	var old int64
+	if info, err := os.Stat(path); err == nil {
+		old = info.Size()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bytes < 0 {
+		t.bytes = t.measure()
+	} else {
+		t.bytes += int64(len(data)) - old
+	}
+	if t.bytes > t.maxBytes {
+		t.prune()
+	}
+	return nil
+}
+
+type cachedTile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// walk lists the cached tiles, leaving out those still being written.
+func (t *tileCache) walk() []cachedTile {
+	var tiles []cachedTile
+	filepath.WalkDir(t.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			tiles = append(tiles, cachedTile{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	return tiles
+}
+
+// measure returns the size of the cache directory. Caller must hold t.mu.
+func (t *tileCache) measure() int64 {
+	var total int64
+	for _, tile := range t.walk() {
+		total += tile.size
+	}
+	return total
+}
+
+// prune deletes the oldest tiles until the cache is back under 90% of its cap,
+// leaving headroom so it does not prune again on the very next tile. Caller
+// must hold t.mu.
+func (t *tileCache) prune() {
+	tiles := t.walk()
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].modTime.Before(tiles[j].modTime) })
+
+	var total int64
+	for _, tile := range tiles {
+		total += tile.size
+	}
+	target := t.maxBytes * 9 / 10
+	removed := 0
+	for _, tile := range tiles {
+		if total <= target {
+			break
+		}
+		if os.Remove(tile.path) == nil {
+			total -= tile.size
+			removed++
+		}
+	}
+	t.bytes = total
+	log.Printf("Tile cache pruned %d tiles, now %d KB", removed, total>>10)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTileCacheServesFromDiskAndWhenOffline(t *testing.T) {
+	var tile bytes.Buffer
+	png.Encode(&tile, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+
+	var hits atomic.Int32
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			http.Error(w, "offline", http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path != "/14/9500/6100.png" {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		w.Write(tile.Bytes())
+	}))
+	defer srv.Close()
+
+	tc := newTileCache(Config{
+		TileCacheDir:  filepath.Join(t.TempDir(), "tiles"),
+		TileCacheMB:   1,
+		TileCacheDays: 30,
+		MapTileURL:    srv.URL + "/{z}/{x}/{y}.png",
+	}, srv.Client())
+
+	for i := 0; i < 3; i++ {
+		if _, err := tc.get(14, 9500, 6100); err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("tile server hit %d times, want 1", n)
+	}
+
+	// Once expired the tile is refetched, but an outage falls back to it.
+	tc.ttl = -time.Second
+	down.Store(true)
+	if _, err := tc.get(14, 9500, 6100); err != nil {
+		t.Errorf("expired tile not served while tile server is down: %v", err)
+	}
+}
+
+func TestConcurrentTileWritesDoNotCollide(t *testing.T) {
+	dir := t.TempDir()
+	tc := newTileCache(Config{TileCacheDir: dir, TileCacheMB: 1}, nil)
+	path := filepath.Join(dir, "14", "9500", "6100")
+	data := bytes.Repeat([]byte("tile"), 1000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tc.store(path, data); err != nil {
+				t.Errorf("store: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("stored tile is %d bytes (%v), want %d", len(got), err, len(data))
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("%d files beside the tile, want it alone", len(entries)-1)
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if n := tc.measure(); n != int64(len(data)) {
+		t.Errorf("cache measures %d bytes, want %d", n, len(data))
+	}
+}
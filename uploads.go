@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"immich-ipad/immich"
+)
+
+const (
+	// uploadCheck is how often Immich is asked for photos added since the
+	// last look, so new uploads reach the frame the same evening rather than
+	// at the next hourly refresh.
+	uploadCheck = 10 * time.Minute
+	// uploadOverlap is how far back each check reaches before the previous
+	// one. A photo can turn up in a source's search only once Immich has read
+	// its EXIF, a little after the upload; seeing one twice is harmless.
+	uploadOverlap = 1 * time.Hour
+)
+
+// freshPhoto is a photo uploaded within FRESH_DAYS, which pickFresh shows
+// sooner than the rest.
+type freshPhoto struct {
+	photo    PhotoInfo
+	uploaded time.Time
+}
+
+// refreshUploads asks Immich, source by source, for photos added or changed
+// since the last check. In INDEX_MODE new ones are appended to the source's
+// index, so they are counted at once and join the rotation from the next
+// cycle. With FRESH_DAYS, those uploaded in that many days are also kept for
+// pickFresh; the first check after a start looks back that far.
+func (c *PhotoCache) refreshUploads(now time.Time) {
+	if !c.cfg.IndexMode && c.cfg.FreshDays == 0 {
+		return
+	}
+	freshAfter := now.AddDate(0, 0, -c.cfg.FreshDays)
+
+	c.mu.Lock()
+	sources := c.sources
+	c.mu.Unlock()
+
+	added, found := 0, 0
+	for _, src := range sources {
+		key := src.key()
+		c.mu.Lock()
+		since, checked := c.checked[key]
+		if !checked {
+			since = c.index[key].BuiltAt
+		}
+		c.mu.Unlock()
+		if !since.IsZero() {
+			since = since.Add(-uploadOverlap)
+		}
+		if !checked && c.cfg.FreshDays > 0 && (since.IsZero() || freshAfter.Before(since)) {
+			since = freshAfter
+		}
+		if since.IsZero() {
+			// Not indexed yet; the walk will find everything.
+			continue
+		}
+
+		start := time.Now()
+		var assets []immich.Asset
+		if _, err := c.walk(src, since, func(a immich.Asset) { assets = append(assets, a) }); err != nil {
+			log.Printf("Checking %q for new photos failed: %v", src.Name, err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.checked[key] = start
+		if c.cfg.IndexMode {
+			added += c.addToIndex(key, assets)
+		}
+		if c.cfg.FreshDays > 0 {
+			found += c.addFresh(assets, freshAfter)
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	fresh := c.fresh[:0]
+	for _, f := range c.fresh {
+		if f.uploaded.After(freshAfter) {
+			fresh = append(fresh, f)
+		}
+	}
+	c.fresh = fresh
+	left := len(fresh)
+	c.mu.Unlock()
+
+	if added > 0 {
+		log.Printf("Added %d new photos to the index", added)
+		if err := c.saveIndex(); err != nil {
+			log.Printf("Index save error: %v", err)
+		}
+	}
+	if found > 0 {
+		log.Printf("Found %d photos uploaded in the last %d days (%d in all)", found, c.cfg.FreshDays, left)
+	}
+}
+
+// addToIndex appends the assets a source's index does not hold yet and updates
+// its count. It returns how many were new. Caller must hold c.mu.
+func (c *PhotoCache) addToIndex(key string, assets []immich.Asset) int {
+	idx, ok := c.index[key]
+	if !ok {
+		return 0
+	}
+	known := make(map[string]bool, len(idx.Entries))
+	for _, e := range idx.Entries {
+		known[e.ID] = true
+	}
+	added := 0
+	for _, a := range assets {
+		if !known[a.ID] {
+			known[a.ID] = true
+			idx.Entries = append(idx.Entries, entryFor(a))
+			added++
+		}
+	}
+	c.index[key] = idx
+	c.maxPages[key] = len(idx.Entries)
+	return added
+}
+
+// addFresh keeps the assets uploaded after freshAfter that are not kept
+// already, and returns how many it added. Caller must hold c.mu.
+func (c *PhotoCache) addFresh(assets []immich.Asset, freshAfter time.Time) int {
+	found := 0
+	for _, a := range assets {
+		uploaded, err := time.Parse(time.RFC3339Nano, a.CreatedAt)
+		if err != nil || !uploaded.After(freshAfter) || c.isFresh(a.ID) {
+			continue
+		}
+		c.fresh = append(c.fresh, freshPhoto{photo: entryFor(a).photo(localeFor(c.cfg.Locale)), uploaded: uploaded})
+		found++
+	}
+	return found
+}
+
+// isFresh reports whether a photo is among the recent uploads. Caller must
+// hold c.mu.
+func (c *PhotoCache) isFresh(id string) bool {
+	for _, f := range c.fresh {
+		if f.photo.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// pickFresh chooses a random recent upload that has not been shown or queued
+// yet. It reports false if there is none, so the caller falls back to the
+// regular rotation. Caller must hold c.mu.
+func (c *PhotoCache) pickFresh() (PhotoInfo, bool) {
+	var candidates []PhotoInfo
+	for _, f := range c.fresh {
+		p := f.photo
+		if !c.shown[p.ID] && !c.queued(p.ID) && !c.onScreen(p.ID) && !c.hidden.has(p.ID) && !c.exclude.tagged[p.ID] && !c.nearDuplicate(p) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return PhotoInfo{}, false
+	}
+	p := candidates[rand.Intn(len(candidates))]
+	log.Printf("Picked %s, a recent upload (%d more to show)", p.ID, len(candidates)-1)
+	return p, true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNewUploadsAreIndexedAndShownFirst(t *testing.T) {
+	c, fake := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 20})
+	c.cfg.IndexMode = true
+	c.cfg.FreshDays = 7
+	c.cfg.FreshPercent = 100
+	if ok := c.refreshTotal(); !ok {
+		t.Fatal("refreshTotal reported no usable counts")
+	}
+	c.refreshUploads(time.Now())
+	if len(c.fresh) != 0 {
+		t.Fatalf("%d fresh photos before any upload, want none", len(c.fresh))
+	}
+
+	uploaded := fake.Upload("iPhone XS", 3, time.Now())
+	calls := fake.Calls()
+	c.refreshUploads(time.Now())
+	if fake.Calls()-calls != 1 {
+		t.Errorf("checking for uploads took %d searches, want 1", fake.Calls()-calls)
+	}
+	if got := c.maxPages["iPhone XS"]; got != 23 {
+		t.Errorf("count = %d after 3 uploads, want 23", got)
+	}
+	entries := c.index["iPhone XS"].Entries
+	var appended []string
+	for _, e := range entries[len(entries)-3:] {
+		appended = append(appended, e.ID)
+	}
+	sort.Strings(appended)
+	sort.Strings(uploaded)
+	if fmt.Sprint(appended) != fmt.Sprint(uploaded) {
+		t.Errorf("last index entries = %v, want the uploads %v", appended, uploaded)
+	}
+
+	var picked []string
+	for i := 0; i < 3; i++ {
+		if !c.fillQueue() {
+			t.Fatalf("nothing picked after %d photos", i)
+		}
+		picked = append(picked, c.next().ID)
+	}
+	sort.Strings(picked)
+	if fmt.Sprint(picked) != fmt.Sprint(uploaded) {
+		t.Errorf("first photos were %v, want the uploads %v", picked, uploaded)
+	}
+}
+
+// Without INDEX_MODE an upload moves every photo a page on, so slots played
+// before it no longer line up; the cycle must still show each photo once.
+func TestUploadMidCycleStillShowsEveryPhotoOnce(t *testing.T) {
+	c, fake := newTestCache(t, []string{"iPhone XS"}, map[string]int{"iPhone XS": 20})
+	c.cfg.ShuffleSeed = 3
+	c.seed = newSeed(c.cfg)
+	c.refreshTotal()
+
+	seen := make(map[string]bool)
+	show := func() string {
+		for tries := 0; !c.fillQueue(); tries++ {
+			if tries == 5 {
+				t.Fatalf("nothing picked after %d photos", len(seen))
+			}
+		}
+		id := c.next().ID
+		if seen[id] {
+			t.Fatalf("%s shown twice in cycle %d", id, c.cycle)
+		}
+		seen[id] = true
+		return id
+	}
+	for i := 0; i < 10; i++ {
+		show()
+	}
+	fake.Upload("iPhone XS", 3, time.Now())
+	c.refreshTotal()
+	for i := 10; i < 23; i++ {
+		show()
+	}
+	for i := 1; i <= 23; i++ {
+		if id := fmt.Sprintf("p%d", i); !seen[id] {
+			t.Errorf("%s was never shown in the cycle", id)
+		}
+	}
+	if c.cycle != 0 {
+		t.Errorf("cycle %d after 23 photos, want the first still", c.cycle)
+	}
+	c.fillQueue()
+	c.next()
+	if c.cycle != 1 {
+		t.Errorf("cycle %d after 24 photos, want the second", c.cycle)
+	}
+}
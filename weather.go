@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// weatherRefresh matches how often the page polls /weather.
+	weatherRefresh = 15 * time.Minute
+	// weatherRetry is how soon a failed refresh is retried.
+	weatherRetry = 1 * time.Minute
+)
+
+// weatherReport is one reading for a location, ready for the overlay: current
+// conditions, today's range, and the next few days.
+type weatherReport struct {
+	Temp string `json:"temp"`
+	Icon string `json:"icon"`
+	High string `json:"high"`
+	Low  string `json:"low"`
+	// Precip is today's highest chance of precipitation, in percent.
+	Precip int `json:"precip"`
+	// Sunrise and Sunset are local times at the location, as "15:04".
+	Sunrise  string        `json:"sunrise"`
+	Sunset   string        `json:"sunset"`
+	Forecast []forecastDay `json:"forecast"`
+}
+
+// forecastDay is one day of the forecast strip.
+type forecastDay struct {
+	Day    string `json:"day"`
+	Icon   string `json:"icon"`
+	High   string `json:"high"`
+	Low    string `json:"low"`
+	Precip int    `json:"precip"`
+}
+
+// forecastDays is how many days after today the forecast strip shows.
+const forecastDays = 3
+
+// WeatherProvider is a source of weather readings, selected by
+// WEATHER_PROVIDER. Providers translate their own condition codes into
+// Condition, so the icon set only has to be mapped once.
+type WeatherProvider interface {
+	Fetch(loc weatherLocation) (weatherData, error)
+}
+
+// weatherLocation is what a reading is for: a place, and the unit its
+// temperatures come back in.
+type weatherLocation struct {
+	Lat, Lon   string
+	Fahrenheit bool
+}
+
+// weatherLocationFor returns the weather location a frame shows.
+func weatherLocationFor(cfg Config) weatherLocation {
+	return weatherLocation{Lat: cfg.WeatherLat, Lon: cfg.WeatherLon, Fahrenheit: cfg.Units == "imperial"}
+}
+
+// newWeatherProvider returns the provider WEATHER_PROVIDER selects.
+func newWeatherProvider(cfg Config, client *http.Client) (WeatherProvider, error) {
+	switch cfg.WeatherProvider {
+	case "", "open-meteo":
+		return &openMeteo{client: client, baseURL: "https://api.open-meteo.com"}, nil
+	case "home-assistant":
+		if cfg.HAURL == "" || cfg.HAToken == "" {
+			return nil, fmt.Errorf("home-assistant weather needs HA_URL and HA_TOKEN")
+		}
+		return &homeAssistant{
+			client:  client,
+			baseURL: strings.TrimRight(cfg.HAURL, "/"),
+			token:   cfg.HAToken,
+			entity:  cfg.HAWeatherEntity,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown WEATHER_PROVIDER %q", cfg.WeatherProvider)
+	}
+}
+
+// weatherData is a provider's reading before it is formatted for the overlay.
+type weatherData struct {
+	Temp      float64
+	Condition Condition
+	IsDay     bool
+	// Days holds today first, then as many following days as are known.
+	Days []weatherDay
+	// Sunrise and Sunset are today's, as "15:04" local to the location.
+	Sunrise string
+	Sunset  string
+}
+
+type weatherDay struct {
+	Date      time.Time
+	Condition Condition
+	High, Low float64
+	// Precip is the highest chance of precipitation, in percent.
+	Precip int
+}
+
+// Condition is a provider-neutral weather condition, one per icon family in
+// templates/weather/.
+type Condition int
+
+const (
+	ConditionUnknown Condition = iota
+	ConditionClear
+	ConditionMostlyClear
+	ConditionPartlyCloudy
+	ConditionMostlyCloudy
+	ConditionCloudy
+	ConditionFog
+	ConditionDrizzle
+	ConditionSleet
+	ConditionRain
+	ConditionHeavyRain
+	ConditionWintryMix
+	ConditionSnow
+	ConditionFlurries
+	ConditionShowers
+	ConditionSnowShowers
+	ConditionThunderstorm
+	ConditionSevereThunderstorm
+)
+
+// icon returns the icon file for a condition, by day or by night.
+func (c Condition) icon(isDay bool) string {
+	dayNight := func(day, night string) string {
+		if isDay {
+			return day
+		}
+		return night
+	}
+	switch c {
+	case ConditionClear:
+		return dayNight("sunny.png", "clear_night.png")
+	case ConditionMostlyClear:
+		return dayNight("mostly_sunny.png", "mostly_clear_night.png")
+	case ConditionPartlyCloudy:
+		return dayNight("partly_cloudy.png", "partly_cloudy_night.png")
+	case ConditionMostlyCloudy:
+		return dayNight("mostly_cloudy_day.png", "mostly_cloudy_night.png")
+	case ConditionFog:
+		return "haze_fog_dust_smoke.png"
+	case ConditionDrizzle:
+		return "drizzle.png"
+	case ConditionSleet:
+		return "sleet_hail.png"
+	case ConditionRain:
+		return "showers_rain.png"
+	case ConditionHeavyRain:
+		return "heavy_rain.png"
+	case ConditionWintryMix:
+		return "wintry_mix_rain_snow.png"
+	case ConditionSnow:
+		return "heavy_snow.png"
+	case ConditionFlurries:
+		return "flurries.png"
+	case ConditionShowers:
+		return dayNight("scattered_showers_day.png", "scattered_showers_night.png")
+	case ConditionSnowShowers:
+		return "snow_showers_snow.png"
+	case ConditionThunderstorm:
+		return dayNight("isolated_scattered_tstorms_day.png", "isolated_scattered_tstorms_night.png")
+	case ConditionSevereThunderstorm:
+		return "strong_tstorms.png"
+	default:
+		return "cloudy.png"
+	}
+}
+
+// newWeatherReport formats a provider's reading for the overlay.
+func newWeatherReport(d weatherData, loc *locale) weatherReport {
+	report := weatherReport{
+		Temp:    formatTemp(d.Temp),
+		Icon:    "/weather-icon/" + d.Condition.icon(d.IsDay),
+		Sunrise: d.Sunrise,
+		Sunset:  d.Sunset,
+	}
+	for i, day := range d.Days {
+		if i > forecastDays {
+			break
+		}
+		if i == 0 {
+			report.High = formatTemp(day.High)
+			report.Low = formatTemp(day.Low)
+			report.Precip = day.Precip
+			continue
+		}
+		report.Forecast = append(report.Forecast, forecastDay{
+			Day:    loc.Weekdays[day.Date.Weekday()],
+			Icon:   "/weather-icon/" + day.Condition.icon(true),
+			High:   formatTemp(day.High),
+			Low:    formatTemp(day.Low),
+			Precip: day.Precip,
+		})
+	}
+	return report
+}
+
+func formatTemp(t float64) string {
+	return fmt.Sprintf("%d", int(math.Round(t)))
+}
+
+// weatherStation holds the last good reading for one location. It keeps the
+// provider's data rather than a formatted report, so frames in different
+// locales can share it.
+type weatherStation struct {
+	loc weatherLocation
+
+	mu          sync.Mutex
+	data        *weatherData
+	fetchedAt   time.Time
+	failing     bool
+	nextRefresh time.Time
+}
+
+// weatherService keeps a reading for every location a frame shows. Only its
+// refresh loop talks to the provider, so the upstream sees one call per
+// location per interval however many frames are polling.
+type weatherService struct {
+	provider WeatherProvider
+
+	mu       sync.Mutex
+	stations map[string]*weatherStation
+}
+
+func newWeatherService(provider WeatherProvider) *weatherService {
+	return &weatherService{provider: provider, stations: make(map[string]*weatherStation)}
+}
+
+// station returns the station for a location, registering it on first use.
+func (ws *weatherService) station(loc weatherLocation) *weatherStation {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	key := fmt.Sprintf("%s,%s,%t", loc.Lat, loc.Lon, loc.Fahrenheit)
+	st, ok := ws.stations[key]
+	if !ok {
+		st = &weatherStation{loc: loc}
+		ws.stations[key] = st
+	}
+	return st
+}
+
+// startRefreshLoop refreshes each station every weatherRefresh, retrying a
+// failed one every weatherRetry. A newly registered station is due at once.
+func (ws *weatherService) startRefreshLoop() {
+	go func() {
+		for {
+			ws.mu.Lock()
+			stations := make([]*weatherStation, 0, len(ws.stations))
+			for _, st := range ws.stations {
+				stations = append(stations, st)
+			}
+			ws.mu.Unlock()
+
+			for _, st := range stations {
+				st.mu.Lock()
+				due := !time.Now().Before(st.nextRefresh)
+				st.mu.Unlock()
+				if due {
+					ws.refresh(st)
+				}
+			}
+			time.Sleep(weatherRetry)
+		}
+	}()
+}
+
+func (ws *weatherService) refresh(st *weatherStation) {
+	data, err := ws.provider.Fetch(st.loc)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err != nil {
+		log.Printf("Weather fetch for %s,%s failed, serving last reading: %v", st.loc.Lat, st.loc.Lon, err)
+		st.failing = true
+		st.nextRefresh = time.Now().Add(weatherRetry)
+		return
+	}
+	st.data = &data
+	st.fetchedAt = time.Now()
+	st.failing = false
+	st.nextRefresh = st.fetchedAt.Add(weatherRefresh)
+}
+
+// reading returns the last good reading, and whether it is stale: the latest
+// refresh failed or it is older than two refresh intervals. ok is false if
+// there has never been a good reading.
+func (st *weatherStation) reading() (data weatherData, stale, ok bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.data == nil {
+		return weatherData{}, false, false
+	}
+	stale = st.failing || time.Since(st.fetchedAt) > 2*weatherRefresh
+	return *st.data, stale, true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeProvider struct {
+	data  weatherData
+	err   error
+	calls int
+}
+
+func (f *fakeProvider) Fetch(loc weatherLocation) (weatherData, error) {
+	f.calls++
+	return f.data, f.err
+}
+
+func TestWeatherServesStaleReadingWhenProviderFails(t *testing.T) {
+	provider := &fakeProvider{data: weatherData{Temp: 21.6, Condition: ConditionClear, IsDay: true}}
+	ws := newWeatherService(provider)
+	loc := weatherLocation{Lat: "40.9", Lon: "29.1"}
+	st := ws.station(loc)
+
+	if _, _, ok := st.reading(); ok {
+		t.Fatal("reading available before any fetch")
+	}
+	ws.refresh(st)
+	data, stale, ok := st.reading()
+	report := newWeatherReport(data, localeFor("tr"))
+	if !ok || stale || report.Temp != "22" || report.Icon != "/weather-icon/sunny.png" {
+		t.Fatalf("reading = %+v stale=%v ok=%v, want a fresh 22° sunny reading", report, stale, ok)
+	}
+
+	provider.err = errors.New("open-meteo is down")
+	ws.refresh(st)
+	data, stale, ok = st.reading()
+	if !ok || !stale || data.Temp != 21.6 {
+		t.Errorf("reading = %+v stale=%v ok=%v, want the last reading marked stale", data, stale, ok)
+	}
+
+	// Every frame polling the same location shares the station.
+	if ws.station(loc) != st {
+		t.Error("same location got a second station")
+	}
+}
+
+func TestOpenMeteoForecast(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"current_weather": {"temperature": 14.2, "weathercode": 61, "is_day": 0},
+			"daily": {
+				"time": ["2024-06-03", "2024-06-04", "2024-06-05", "2024-06-06"],
+				"weathercode": [61, 0, 3, 95],
+				"temperature_2m_max": [18.4, 22, 20, 17],
+				"temperature_2m_min": [11.6, 12, 13, 10],
+				"precipitation_probability_max": [80, 0, null, 60],
+				"sunrise": ["2024-06-03T05:32", "", "", ""],
+				"sunset": ["2024-06-03T20:31", "", "", ""]
+			}
+		}`)
+	}))
+	defer srv.Close()
+
+	data, err := (&openMeteo{client: srv.Client(), baseURL: srv.URL}).Fetch(weatherLocation{Lat: "40.9", Lon: "29.1"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	report := newWeatherReport(data, localeFor("tr"))
+	if report.Icon != "/weather-icon/showers_rain.png" || report.High != "18" || report.Low != "12" || report.Precip != 80 {
+		t.Errorf("today = %+v", report)
+	}
+	if report.Sunrise != "05:32" || report.Sunset != "20:31" {
+		t.Errorf("sun times = %s/%s, want 05:32/20:31", report.Sunrise, report.Sunset)
+	}
+	if len(report.Forecast) != 3 || report.Forecast[0].Day != "Sal" || report.Forecast[2].Icon != "/weather-icon/isolated_scattered_tstorms_day.png" {
+		t.Errorf("forecast = %+v", report.Forecast)
+	}
+}
+
+func TestHomeAssistantForecast(t *testing.T) {
+	serviceDown := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/states/weather.home":
+			fmt.Fprint(w, `{"state": "pouring", "attributes": {
+				"temperature": 59, "temperature_unit": "°F",
+				"forecast": [{"datetime": "2024-06-03T10:00:00+00:00", "condition": "snowy", "temperature": 32}]
+			}}`)
+		case "GET /api/states/sun.sun":
+			fmt.Fprint(w, `{"state": "below_horizon", "attributes": {
+				"next_rising": "2024-06-04T05:32:00+03:00", "next_setting": "2024-06-03T20:31:00+03:00"
+			}}`)
+		case "POST /api/services/weather/get_forecasts":
+			if serviceDown {
+				http.Error(w, "unknown service", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"service_response": {"weather.home": {"forecast": [
+				{"datetime": "2024-06-04T10:00:00+00:00", "condition": "sunny", "temperature": 77, "templow": 59},
+				{"datetime": "2024-06-03T10:00:00+00:00", "condition": "lightning-rainy", "temperature": 68, "templow": 50, "precipitation_probability": 90}
+			]}}}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ha := &homeAssistant{client: srv.Client(), baseURL: srv.URL, token: "token", entity: "weather.home"}
+	data, err := ha.Fetch(weatherLocation{})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	report := newWeatherReport(data, localeFor("en"))
+	if report.Temp != "15" || report.Icon != "/weather-icon/heavy_rain.png" {
+		t.Errorf("now = %s° %s, want 15° heavy rain by night", report.Temp, report.Icon)
+	}
+	if report.High != "20" || report.Low != "10" || report.Precip != 90 {
+		t.Errorf("today = %s/%s %d%%, want the earliest forecast day in °C", report.High, report.Low, report.Precip)
+	}
+	if len(report.Forecast) != 1 || report.Forecast[0].Icon != "/weather-icon/sunny.png" || report.Forecast[0].High != "25" {
+		t.Errorf("forecast = %+v", report.Forecast)
+	}
+	if want := localClock("2024-06-04T05:32:00+03:00"); report.Sunrise != want {
+		t.Errorf("sunrise = %q, want %q", report.Sunrise, want)
+	}
+
+	// Releases before 2024.3 have no forecast service, only the attribute.
+	serviceDown = true
+	data, err = ha.Fetch(weatherLocation{Fahrenheit: true})
+	if err != nil {
+		t.Fatalf("Fetch without the forecast service: %v", err)
+	}
+	if len(data.Days) != 1 || data.Days[0].Condition != ConditionSnow || data.Days[0].High != 32 || data.Temp != 59 {
+		t.Errorf("attribute forecast = %+v", data)
+	}
+}
+
+func TestHomeAssistantConditions(t *testing.T) {
+	for state, want := range map[string]Condition{
+		"sunny":           ConditionClear,
+		"clear-night":     ConditionClear,
+		"partlycloudy":    ConditionPartlyCloudy,
+		"cloudy":          ConditionCloudy,
+		"windy-variant":   ConditionMostlyCloudy,
+		"fog":             ConditionFog,
+		"hail":            ConditionSleet,
+		"rainy":           ConditionRain,
+		"pouring":         ConditionHeavyRain,
+		"snowy-rainy":     ConditionWintryMix,
+		"snowy":           ConditionSnow,
+		"lightning-rainy": ConditionThunderstorm,
+		"exceptional":     ConditionUnknown,
+	} {
+		if got := haCondition(state); got != want {
+			t.Errorf("haCondition(%q) = %d, want %d", state, got, want)
+		}
+	}
+}